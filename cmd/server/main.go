@@ -25,21 +25,48 @@ func main() {
 	http.Handle("/static/", noCache(http.StripPrefix("/static/", fs)))
 
 	// API Endpoints
-	http.HandleFunc("/api/players", handlers.PlayersHandler)                    // GET, POST
-	http.HandleFunc("/api/players/import", handlers.ImportPlayersHandler)       // POST
-	http.HandleFunc("/api/players/delete", handlers.DeletePlayerHandler)        // POST
-	http.HandleFunc("/api/flights", handlers.FlightsHandler)                    // GET, POST (create)
-	http.HandleFunc("/api/flights/update", handlers.UpdateFlightHandler)        // POST
-	http.HandleFunc("/api/flights/assign", handlers.AssignPlayerHandler)        // POST (assign)
-	http.HandleFunc("/api/flights/unassign", handlers.UnassignPlayerHandler)    // POST (unassign)
-	http.HandleFunc("/api/flights/random-assign", handlers.RandomAssignHandler) // POST
-	http.HandleFunc("/api/scores", handlers.ScoresHandler)                      // POST (submit)
-	http.HandleFunc("/api/results", handlers.ResultsHandler)                    // GET
-	http.HandleFunc("/api/course", handlers.CourseHandler)                      // GET, POST
-	http.HandleFunc("/api/course/import", handlers.ImportCourseHandler)         // POST
-	http.HandleFunc("/api/course/export", handlers.ExportCourseHandler)         // GET
-	http.HandleFunc("/api/players/fetch-hcp", handlers.FetchHCPHandler)         // POST
-	http.HandleFunc("/api/settings", handlers.SettingsHandler)                  // GET, POST
+	http.HandleFunc("/api/players", handlers.PlayersHandler)                      // GET, POST
+	http.HandleFunc("/api/players/import", handlers.ImportPlayersHandler)         // POST
+	http.HandleFunc("/api/players/delete", handlers.DeletePlayerHandler)          // POST
+	http.HandleFunc("/api/flights", handlers.FlightsHandler)                      // GET, POST (create)
+	http.HandleFunc("/api/flights/update", handlers.UpdateFlightHandler)          // POST
+	http.HandleFunc("/api/flights/assign", handlers.AssignPlayerHandler)          // POST (assign)
+	http.HandleFunc("/api/flights/unassign", handlers.UnassignPlayerHandler)      // POST (unassign)
+	http.HandleFunc("/api/flights/random-assign", handlers.RandomAssignHandler)   // POST
+	http.HandleFunc("/api/flights/tee-times", handlers.GenerateTeeTimesHandler)   // POST
+	http.HandleFunc("/api/flights/shotgun", handlers.ShotgunHandler)              // POST
+	http.HandleFunc("/api/flights/rotate-token", handlers.RotateTokenHandler)     // POST
+	http.HandleFunc("/api/flights/qr", handlers.FlightQRHandler)                  // GET (?id, format=png|svg)
+	http.HandleFunc("/api/flights/qr-sheet", handlers.QRSheetHandler)             // GET
+	http.HandleFunc("/api/flights/scorecards", handlers.ScorecardsHandler)        // GET (PDF, ?id for one flight)
+	http.HandleFunc("/api/token", handlers.TokenStatusHandler)                    // GET
+	http.HandleFunc("/api/flight", handlers.FlightByTokenHandler)                 // GET (by token)
+	http.HandleFunc("/api/teesheet", handlers.TeeSheetHandler)                    // GET
+	http.HandleFunc("/api/draws", handlers.DrawsHandler)                          // GET
+	http.HandleFunc("/api/draws/replay", handlers.ReplayDrawHandler)              // POST
+	http.HandleFunc("/api/constraints", handlers.ConstraintsHandler)              // GET, POST, DELETE
+	http.HandleFunc("/api/scores", handlers.ScoresHandler)                        // POST (submit)
+	http.HandleFunc("/api/scores/batch", handlers.BatchScoresHandler)             // POST
+	http.HandleFunc("/api/scores/sync", handlers.SyncHandler)                     // POST (offline queue)
+	http.HandleFunc("/api/scores/history", handlers.ScoreHistoryHandler)          // GET (?player_id)
+	http.HandleFunc("/api/scores/conflicts", handlers.ConflictsHandler)           // GET, POST (resolve)
+	http.HandleFunc("/api/scores/correct", handlers.CorrectScoreHandler)          // POST (admin, any state)
+	http.HandleFunc("/api/scorecards", handlers.CardStatusesHandler)              // GET
+	http.HandleFunc("/api/scorecards/status", handlers.CardStatusHandler)         // POST
+	http.HandleFunc("/api/scorecards/marker", handlers.CardMarkerHandler)         // POST
+	http.HandleFunc("/api/round-status", handlers.RoundStatusHandler)             // GET, POST
+	http.HandleFunc("/api/results", handlers.ResultsHandler)                      // GET
+	http.HandleFunc("/api/results/stream", handlers.ResultsStreamHandler)         // GET (Server-Sent Events)
+	http.HandleFunc("/api/stats/players", handlers.PlayerStatsHandler)            // GET (?player_id)
+	http.HandleFunc("/api/stats/field", handlers.FieldStatsHandler)               // GET
+	http.HandleFunc("/api/course", handlers.CourseHandler)                        // GET, POST
+	http.HandleFunc("/api/course/import", handlers.ImportCourseHandler)           // POST
+	http.HandleFunc("/api/course/export", handlers.ExportCourseHandler)           // GET
+	http.HandleFunc("/api/players/fetch-hcp", handlers.FetchHCPHandler)           // POST
+	http.HandleFunc("/api/settings", handlers.SettingsHandler)                    // GET, POST
+	http.HandleFunc("/api/handicaps", handlers.HandicapsHandler)                  // GET
+	http.HandleFunc("/api/handicaps/snapshot", handlers.SnapshotHandicapsHandler) // POST
+	http.HandleFunc("/api/handicaps/apply", handlers.ApplyHandicapsHandler)       // POST
 
 	// Admin Pages
 	http.HandleFunc("/adminpage", func(w http.ResponseWriter, r *http.Request) {
@@ -78,5 +105,5 @@ func main() {
 	})
 
 	log.Println("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", handlers.TrackChanges(http.DefaultServeMux)))
 }
@@ -37,7 +37,11 @@ func createTables() {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		token TEXT UNIQUE,
 		name TEXT,
-		starting_hole INTEGER DEFAULT 1
+		starting_hole INTEGER DEFAULT 1,
+		starting_suffix TEXT DEFAULT '',
+		tee_time TEXT DEFAULT '',
+		tee_time_manual INTEGER DEFAULT 0,
+		max_players INTEGER DEFAULT 0
 	);`
 
 	createFlightPlayersTable := `CREATE TABLE IF NOT EXISTS flight_players (
@@ -52,6 +56,12 @@ func createTables() {
 		player_id INTEGER,
 		hole_number INTEGER,
 		strokes INTEGER,
+		version INTEGER DEFAULT 1,
+		putts INTEGER,
+		fairway_hit INTEGER,
+		gir INTEGER,
+		penalties INTEGER,
+		bunker INTEGER,
 		FOREIGN KEY(player_id) REFERENCES players(id)
 	);`
 
@@ -59,7 +69,8 @@ func createTables() {
 		hole_number INTEGER PRIMARY KEY,
 		par INTEGER,
 		length_yellow INTEGER DEFAULT 0,
-		length_red INTEGER DEFAULT 0
+		length_red INTEGER DEFAULT 0,
+		stroke_index INTEGER DEFAULT 0
 	);`
 
 	createSettingsTable := `CREATE TABLE IF NOT EXISTS settings (
@@ -67,6 +78,97 @@ func createTables() {
 		value TEXT
 	);`
 
+	createHandicapSnapshotsTable := `CREATE TABLE IF NOT EXISTS handicap_snapshots (
+		player_id INTEGER PRIMARY KEY,
+		handicap_index REAL,
+		playing_handicap INTEGER,
+		taken_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(player_id) REFERENCES players(id)
+	);`
+
+	createDrawsTable := `CREATE TABLE IF NOT EXISTS draws (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		seed TEXT,
+		inputs TEXT,
+		pairings TEXT
+	);`
+
+	createPairingConstraintsTable := `CREATE TABLE IF NOT EXISTS pairing_constraints (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT,
+		player_id INTEGER,
+		other_player_id INTEGER,
+		flight_id INTEGER,
+		note TEXT,
+		FOREIGN KEY(player_id) REFERENCES players(id),
+		FOREIGN KEY(other_player_id) REFERENCES players(id),
+		FOREIGN KEY(flight_id) REFERENCES flights(id)
+	);`
+
+	createRoundStatusesTable := `CREATE TABLE IF NOT EXISTS round_statuses (
+		player_id INTEGER PRIMARY KEY,
+		status TEXT,
+		reason TEXT,
+		set_at TEXT,
+		source TEXT,
+		FOREIGN KEY(player_id) REFERENCES players(id)
+	);`
+
+	createScorecardsTable := `CREATE TABLE IF NOT EXISTS scorecards (
+		player_id INTEGER PRIMARY KEY,
+		status TEXT DEFAULT 'in_progress',
+		marker_id INTEGER,
+		submitted_at TEXT,
+		accepted_at TEXT,
+		verified_at TEXT,
+		FOREIGN KEY(player_id) REFERENCES players(id),
+		FOREIGN KEY(marker_id) REFERENCES players(id)
+	);`
+
+	createScoreHistoryTable := `CREATE TABLE IF NOT EXISTS score_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		player_id INTEGER,
+		hole_number INTEGER,
+		old_strokes INTEGER,
+		new_strokes INTEGER,
+		changed_at TEXT,
+		source TEXT,
+		flight_id INTEGER,
+		client_ip TEXT,
+		user_agent TEXT,
+		reason TEXT,
+		FOREIGN KEY(player_id) REFERENCES players(id)
+	);`
+
+	createScoreConflictsTable := `CREATE TABLE IF NOT EXISTS score_conflicts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		player_id INTEGER,
+		hole_number INTEGER,
+		server_strokes INTEGER,
+		server_version INTEGER,
+		client_strokes INTEGER,
+		created_at TEXT,
+		source TEXT,
+		flight_id INTEGER,
+		resolved_at TEXT,
+		FOREIGN KEY(player_id) REFERENCES players(id)
+	);`
+
+	createSyncEntriesTable := `CREATE TABLE IF NOT EXISTS sync_entries (
+		idempotency_key TEXT,
+		flight_id INTEGER,
+		player_id INTEGER,
+		hole_number INTEGER,
+		strokes INTEGER,
+		captured_at TEXT,
+		received_at TEXT,
+		status TEXT,
+		error TEXT,
+		version INTEGER,
+		PRIMARY KEY (flight_id, idempotency_key)
+	);`
+
 	_, err := DB.Exec(createPlayersTable)
 	if err != nil {
 		log.Fatal(err)
@@ -97,11 +199,94 @@ func createTables() {
 		log.Fatal(err)
 	}
 
+	_, err = DB.Exec(createHandicapSnapshotsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createDrawsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createPairingConstraintsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createScorecardsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createScoreHistoryTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createScoreConflictsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createSyncEntriesTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = DB.Exec(createRoundStatusesTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Migrations: Add length if it doesn't exist
 	_, _ = DB.Exec("ALTER TABLE holes ADD COLUMN length_red INTEGER DEFAULT 0")
 	_, _ = DB.Exec("ALTER TABLE holes RENAME COLUMN length TO length_yellow")
 	_, _ = DB.Exec("ALTER TABLE flights ADD COLUMN starting_hole INTEGER DEFAULT 1")
 	_, _ = DB.Exec("ALTER TABLE players ADD COLUMN gender TEXT DEFAULT 'M'")
+	_, _ = DB.Exec("ALTER TABLE flights ADD COLUMN tee_time TEXT DEFAULT ''")
+	_, _ = DB.Exec("ALTER TABLE flights ADD COLUMN tee_time_manual INTEGER DEFAULT 0")
+	_, _ = DB.Exec("ALTER TABLE flights ADD COLUMN max_players INTEGER DEFAULT 0")
+	_, _ = DB.Exec("ALTER TABLE flights ADD COLUMN starting_suffix TEXT DEFAULT ''")
+	_, _ = DB.Exec("ALTER TABLE holes ADD COLUMN stroke_index INTEGER DEFAULT 0")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN version INTEGER DEFAULT 1")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN putts INTEGER")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN fairway_hit INTEGER")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN gir INTEGER")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN penalties INTEGER")
+	_, _ = DB.Exec("ALTER TABLE scores ADD COLUMN bunker INTEGER")
+	_, _ = DB.Exec("ALTER TABLE score_history ADD COLUMN reason TEXT")
+
+	// Migration: idempotency keys are per flight. A table with the key alone
+	// as primary key is rebuilt.
+	var syncKeyColumns int
+	DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sync_entries') WHERE pk > 0").Scan(&syncKeyColumns)
+	if syncKeyColumns == 1 {
+		for _, stmt := range []string{
+			"ALTER TABLE sync_entries RENAME TO sync_entries_old",
+			createSyncEntriesTable,
+			"INSERT INTO sync_entries SELECT * FROM sync_entries_old",
+			"DROP TABLE sync_entries_old",
+		} {
+			if _, err := DB.Exec(stmt); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// Migration: one score per player and hole. Duplicates left by earlier
+	// concurrent writes are removed first. Later edits always updated the
+	// first row found, the lowest id, so that one holds the current score.
+	_, err = DB.Exec(`DELETE FROM scores WHERE id NOT IN (
+		SELECT MIN(id) FROM scores GROUP BY player_id, hole_number
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_scores_player_hole ON scores (player_id, hole_number)")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize settings if empty
 	var scoringEnabledExists int
@@ -115,7 +300,7 @@ func createTables() {
 	DB.QueryRow("SELECT COUNT(*) FROM holes").Scan(&count)
 	if count == 0 {
 		for i := 1; i <= 18; i++ {
-			DB.Exec("INSERT INTO holes (hole_number, par, length_yellow, length_red) VALUES (?, ?, ?, ?)", i, 4, 300, 250)
+			DB.Exec("INSERT INTO holes (hole_number, par, length_yellow, length_red, stroke_index) VALUES (?, ?, ?, ?, ?)", i, 4, 300, 250, i)
 		}
 	}
 }
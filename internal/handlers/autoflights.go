@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// How auto-created flights start.
+const (
+	startTeeTimes = "tee_times" // consecutive tee times after the existing flights
+	startShotgun  = "shotgun"   // same time, spread over the course with the rest
+)
+
+// plannedFlight is a flight the draw is going to create.
+type plannedFlight struct {
+	Name           string `json:"name"`
+	Size           int    `json:"size"`
+	StartingHole   int    `json:"starting_hole"`
+	StartingSuffix string `json:"starting_suffix"`
+	TeeTime        string `json:"tee_time"`
+}
+
+// splitFlightSizes splits n players into flights of the given size. A last
+// flight with a single player is avoided by taking players from the flights
+// before it, which turns 4,4,1 into 3,3,3.
+func splitFlightSizes(n, size int) []int {
+	var sizes []int
+	for n > 0 {
+		s := min(size, n)
+		sizes = append(sizes, s)
+		n -= s
+	}
+
+	last := len(sizes) - 1
+	if last > 0 && sizes[last] == 1 {
+		target := min(3, size-1)
+		for i := last - 1; i >= 0 && sizes[last] < target; i-- {
+			if sizes[i] > target {
+				sizes[i]--
+				sizes[last]++
+			}
+		}
+	}
+	return sizes
+}
+
+// planFlights works out the flights needed for extra players that don't fit
+// into the existing ones. For a shotgun start the whole field is spread over
+// the course again by allocateShotgun, so new flights double up as A/B groups
+// like any other; the returned slots move the existing flights to match.
+// shotgunAt is the shotgun time, empty for the one the field already has.
+func planFlights(extra int, start, shotgunAt string) ([]plannedFlight, []shotgunSlot, error) {
+	if extra <= 0 {
+		return nil, nil, nil
+	}
+	existing, err := loadFlights()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sizes := splitFlightSizes(extra, defaultFlightSize())
+	cfg := loadTeeTimeConfig()
+	plan := make([]plannedFlight, len(sizes))
+	next := nextFlightNumber(existing)
+	for i := range plan {
+		plan[i].Name = fmt.Sprintf("Flight %d", next+i)
+		plan[i].Size = sizes[i]
+	}
+
+	var moved []shotgunSlot
+	switch start {
+	case startShotgun:
+		holes, err := loadHoles()
+		if err != nil {
+			return nil, nil, err
+		}
+		all := append([]*flightWithPlayers(nil), existing...)
+		for _, p := range plan {
+			all = append(all, &flightWithPlayers{Flight: models.Flight{Name: p.Name}})
+		}
+		slots, err := allocateShotgun(all, holes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if shotgunAt == "" {
+			shotgunAt = fieldShotgunTime(existing, cfg)
+		}
+		// Slots come in the order of the flights, the existing ones first
+		for i, s := range slots {
+			if i < len(existing) {
+				moved = append(moved, s)
+				continue
+			}
+			p := &plan[i-len(existing)]
+			p.StartingHole, p.StartingSuffix, p.TeeTime = s.StartingHole, s.Suffix, shotgunAt
+		}
+	default:
+		slot := 0
+		for _, f := range existing {
+			if !f.TeeTimeManual {
+				slot++
+			}
+		}
+		for i := range plan {
+			plan[i].TeeTime, plan[i].StartingHole = cfg.slot(slot + i)
+		}
+	}
+	return plan, moved, nil
+}
+
+// nextFlightNumber returns the number after the highest "Flight N" name, so
+// new names don't collide after flights were deleted or named by hand.
+func nextFlightNumber(existing []*flightWithPlayers) int {
+	highest := 0
+	for _, f := range existing {
+		var n int
+		var rest string
+		if k, _ := fmt.Sscanf(f.Name, "Flight %d%s", &n, &rest); k == 1 && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1
+}
+
+// fieldShotgunTime is the time the existing flights go off together, or the
+// first tee time if they don't share one yet.
+func fieldShotgunTime(existing []*flightWithPlayers, cfg teeTimeConfig) string {
+	at := ""
+	for _, f := range existing {
+		if f.TeeTimeManual || f.TeeTime == "" {
+			continue
+		}
+		if at != "" && f.TeeTime != at {
+			return cfg.First.Format(teeTimeLayout)
+		}
+		at = f.TeeTime
+	}
+	if at == "" {
+		return cfg.First.Format(teeTimeLayout)
+	}
+	return at
+}
+
+// createPlannedFlights inserts the planned flights and returns them as draw
+// flights. A flight smaller than the default size gets its own limit, so
+// the draw fills it exactly and it isn't reported as under-filled.
+func createPlannedFlights(tx *sql.Tx, plan []plannedFlight) ([]drawFlight, error) {
+	defaultSize := defaultFlightSize()
+	var flights []drawFlight
+	for _, p := range plan {
+		maxPlayers := 0
+		if p.Size != defaultSize {
+			maxPlayers = p.Size
+		}
+		token, err := newFlightToken()
+		if err != nil {
+			return nil, err
+		}
+		res, err := tx.Exec("INSERT INTO flights (token, name, starting_hole, starting_suffix, tee_time, max_players) VALUES (?, ?, ?, ?, ?, ?)", token, p.Name, p.StartingHole, p.StartingSuffix, p.TeeTime, maxPlayers)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := res.LastInsertId()
+		flights = append(flights, drawFlight{ID: int(id), Capacity: p.Size})
+	}
+	return flights, nil
+}
+
+// previewFlights stands in for planned flights in a preview, using negative
+// IDs since nothing has been created yet.
+func previewFlights(plan []plannedFlight) []drawFlight {
+	flights := make([]drawFlight, len(plan))
+	for i, p := range plan {
+		flights[i] = drawFlight{ID: -(i + 1), Capacity: p.Size}
+	}
+	return flights
+}
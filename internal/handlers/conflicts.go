@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// scoreConflict is returned by saveScore when the client wrote on top of a
+// score it hadn't seen. It is also the 409 response body.
+type scoreConflict struct {
+	ID            int    `json:"conflict_id"`
+	PlayerID      int    `json:"player_id"`
+	HoleNumber    int    `json:"hole_number"`
+	ServerStrokes int    `json:"server_strokes"` // 0 = no score
+	ServerVersion int    `json:"server_version"`
+	ClientStrokes int    `json:"client_strokes"`
+	Message       string `json:"error"`
+}
+
+func (c *scoreConflict) Error() string {
+	return c.Message
+}
+
+// recordConflict stores the conflict for the committee and fills in its ID
+// and message.
+func recordConflict(tx *sql.Tx, c *scoreConflict, src scoreSource) error {
+	c.Message = fmt.Sprintf("Score was changed by someone else: server has %d, you entered %d", c.ServerStrokes, c.ClientStrokes)
+	var flight interface{}
+	if src.FlightID != 0 {
+		flight = src.FlightID
+	}
+	res, err := tx.Exec(`
+		INSERT INTO score_conflicts (player_id, hole_number, server_strokes, server_version, client_strokes, created_at, source, flight_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.PlayerID, c.HoleNumber, c.ServerStrokes, c.ServerVersion, c.ClientStrokes, time.Now().UTC().Format(time.RFC3339), src.Source, flight)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	c.ID = int(id)
+	return nil
+}
+
+// ConflictsHandler lists the unresolved score conflicts (?all=1 for all of
+// them) and, on POST {"id": n}, marks one as resolved. Admin only.
+func ConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	if r.Method == http.MethodGet {
+		query := `
+			SELECT id, player_id, hole_number, server_strokes, server_version, client_strokes,
+				created_at, COALESCE(source, ''), COALESCE(flight_id, 0), COALESCE(resolved_at, '')
+			FROM score_conflicts`
+		if r.URL.Query().Get("all") != "1" {
+			query += " WHERE resolved_at IS NULL"
+		}
+		rows, err := db.DB.Query(query + " ORDER BY id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		conflicts := []models.ScoreConflict{}
+		for rows.Next() {
+			var c models.ScoreConflict
+			if err := rows.Scan(&c.ID, &c.PlayerID, &c.HoleNumber, &c.ServerStrokes, &c.ServerVersion, &c.ClientStrokes, &c.CreatedAt, &c.Source, &c.FlightID, &c.ResolvedAt); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			conflicts = append(conflicts, c)
+		}
+		json.NewEncoder(w).Encode(conflicts)
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := db.DB.Exec("UPDATE score_conflicts SET resolved_at = ? WHERE id = ? AND resolved_at IS NULL", time.Now().UTC().Format(time.RFC3339), req.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Conflict not found or already resolved", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// Pairing constraint kinds.
+const (
+	constraintTogether = "together" // must play in the same flight
+	constraintApart    = "apart"    // must not play in the same flight
+	constraintFixed    = "fixed"    // must play in the given flight
+)
+
+type constraintViolation struct {
+	ConstraintID int    `json:"constraint_id"`
+	Kind         string `json:"kind"`
+	Reason       string `json:"reason"`
+}
+
+// loadConstraints returns all constraints whose players still exist.
+func loadConstraints() ([]models.PairingConstraint, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, kind, player_id, COALESCE(other_player_id, 0), COALESCE(flight_id, 0), COALESCE(note, '')
+		FROM pairing_constraints
+		WHERE player_id IN (SELECT id FROM players)
+		AND (other_player_id IS NULL OR other_player_id IN (SELECT id FROM players))
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	constraints := []models.PairingConstraint{}
+	for rows.Next() {
+		var c models.PairingConstraint
+		if err := rows.Scan(&c.ID, &c.Kind, &c.PlayerID, &c.OtherPlayerID, &c.FlightID, &c.Note); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, rows.Err()
+}
+
+// constraintSet indexes the constraints for the draw.
+type constraintSet struct {
+	fixed  map[int]int          // player ID -> flight ID
+	apart  map[int]map[int]bool // player ID -> players to keep away from
+	parent map[int]int          // union-find over "together"
+}
+
+func newConstraintSet(constraints []models.PairingConstraint) *constraintSet {
+	c := &constraintSet{
+		fixed:  make(map[int]int),
+		apart:  make(map[int]map[int]bool),
+		parent: make(map[int]int),
+	}
+	for _, pc := range constraints {
+		switch pc.Kind {
+		case constraintFixed:
+			if _, ok := c.fixed[pc.PlayerID]; !ok {
+				c.fixed[pc.PlayerID] = pc.FlightID
+			}
+		case constraintApart:
+			c.addApart(pc.PlayerID, pc.OtherPlayerID)
+			c.addApart(pc.OtherPlayerID, pc.PlayerID)
+		case constraintTogether:
+			c.parent[c.find(pc.PlayerID)] = c.find(pc.OtherPlayerID)
+		}
+	}
+	return c
+}
+
+func (c *constraintSet) addApart(a, b int) {
+	if c.apart[a] == nil {
+		c.apart[a] = make(map[int]bool)
+	}
+	c.apart[a][b] = true
+}
+
+func (c *constraintSet) find(id int) int {
+	p, ok := c.parent[id]
+	if !ok || p == id {
+		return id
+	}
+	root := c.find(p)
+	c.parent[id] = root
+	return root
+}
+
+// units groups players that must play together, in order of first appearance.
+func (c *constraintSet) units(ids []int) [][]int {
+	var units [][]int
+	index := make(map[int]int)
+	for _, id := range ids {
+		root := c.find(id)
+		if i, ok := index[root]; ok {
+			units[i] = append(units[i], id)
+			continue
+		}
+		index[root] = len(units)
+		units = append(units, []int{id})
+	}
+	return units
+}
+
+// conflicts reports whether any player of the unit must be kept apart from
+// any of the others.
+func (c *constraintSet) conflicts(unit, others []int) bool {
+	for _, a := range unit {
+		for _, b := range others {
+			if c.apart[a][b] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkConstraints evaluates every constraint against the flights after the
+// draw. flightOf maps player ID to flight ID for everyone with a flight.
+func checkConstraints(constraints []models.PairingConstraint, flightOf map[int]int) []constraintViolation {
+	violations := []constraintViolation{}
+	for _, pc := range constraints {
+		fa, fb := flightOf[pc.PlayerID], flightOf[pc.OtherPlayerID]
+		reason := ""
+		switch pc.Kind {
+		case constraintTogether:
+			if fa == 0 || fb == 0 {
+				reason = "not both players have a flight"
+			} else if fa != fb {
+				reason = fmt.Sprintf("players are in flights %d and %d", fa, fb)
+			}
+		case constraintApart:
+			if fa != 0 && fa == fb {
+				reason = fmt.Sprintf("players are both in flight %d", fa)
+			}
+		case constraintFixed:
+			if fa == 0 {
+				reason = fmt.Sprintf("player has no flight instead of flight %d", pc.FlightID)
+			} else if fa != pc.FlightID {
+				reason = fmt.Sprintf("player is in flight %d instead of flight %d", fa, pc.FlightID)
+			}
+		}
+		if reason != "" {
+			violations = append(violations, constraintViolation{ConstraintID: pc.ID, Kind: pc.Kind, Reason: reason})
+		}
+	}
+	return violations
+}
+
+// validateConstraintRefs checks that the players and the flight a
+// constraint refers to exist.
+func validateConstraintRefs(tx *sql.Tx, c models.PairingConstraint) error {
+	verr := &validationError{}
+	refs := []struct {
+		field, table, noun string
+		id                 int
+	}{
+		{"player_id", "players", "player", c.PlayerID},
+		{"other_player_id", "players", "player", c.OtherPlayerID},
+		{"flight_id", "flights", "flight", c.FlightID},
+	}
+	for _, ref := range refs {
+		if ref.field != "player_id" && ref.id == 0 {
+			continue
+		}
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM "+ref.table+" WHERE id = ?", ref.id).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			verr.add(ref.field, fmt.Sprintf("%s %d does not exist", ref.noun, ref.id))
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ConstraintsHandler lists, creates and deletes pairing constraints. Creating
+// and deleting is for the committee only, as constraints steer the draw.
+func ConstraintsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	if r.Method == http.MethodGet {
+		constraints, err := loadConstraints()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(constraints)
+	} else if r.Method == http.MethodPost {
+		var c models.PairingConstraint
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var other, flight interface{}
+		switch c.Kind {
+		case constraintTogether, constraintApart:
+			if c.OtherPlayerID == 0 || c.OtherPlayerID == c.PlayerID {
+				http.Error(w, "other_player_id must be a different player", http.StatusBadRequest)
+				return
+			}
+			other, c.FlightID = c.OtherPlayerID, 0
+		case constraintFixed:
+			if c.FlightID == 0 {
+				http.Error(w, "Missing flight_id", http.StatusBadRequest)
+				return
+			}
+			flight, c.OtherPlayerID = c.FlightID, 0
+		default:
+			http.Error(w, "kind must be together, apart or fixed", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = validateConstraintRefs(tx, c)
+		var invalid *validationError
+		if errors.As(err, &invalid) {
+			tx.Rollback()
+			writeValidationError(w, "Invalid constraint", invalid)
+			return
+		} else if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err := tx.Exec("INSERT INTO pairing_constraints (kind, player_id, other_player_id, flight_id, note) VALUES (?, ?, ?, ?, ?)", c.Kind, c.PlayerID, other, flight, c.Note)
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		c.ID = int(id)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	} else if r.Method == http.MethodDelete {
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := db.DB.Exec("DELETE FROM pairing_constraints WHERE id = ?", req.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,719 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	mrand "math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// Draw strategies accepted by RandomAssignHandler.
+const (
+	drawRandom      = "random"       // shuffle and fill flights in order
+	drawSnake       = "snake"        // snake draft by handicap, one player per band in each flight
+	drawGrouped     = "grouped"      // similar handicaps play together
+	drawMixedGender = "mixed_gender" // spread women and men evenly over the flights
+)
+
+// drawInput is everything a draw depends on apart from the seed. It is stored
+// with the draw record, so replaying a draw never looks at the live tables.
+type drawInput struct {
+	Strategy    string                     `json:"strategy"`
+	Players     []drawPlayer               `json:"players"` // unassigned players, sorted by ID
+	Flights     []drawFlight               `json:"flights"` // flights in fill order
+	Constraints []models.PairingConstraint `json:"constraints,omitempty"`
+}
+
+type drawPlayer struct {
+	ID       int     `json:"id"`
+	Handicap float64 `json:"handicap"`
+	Gender   string  `json:"gender"`
+}
+
+type drawFlight struct {
+	ID       int   `json:"id"`
+	Taken    int   `json:"taken"`              // number of players already in the flight
+	Players  []int `json:"players,omitempty"`  // their IDs
+	Capacity int   `json:"capacity,omitempty"` // max players, 0 in draws made before it was configurable
+}
+
+// free returns the number of places left in the flight.
+func (f drawFlight) free() int {
+	capacity := f.Capacity
+	if capacity == 0 {
+		capacity = 4
+	}
+	return max(0, capacity-f.Taken)
+}
+
+type drawAssignment struct {
+	FlightID  int   `json:"flight_id"`
+	PlayerIDs []int `json:"player_ids"`
+}
+
+type drawResult struct {
+	Assignments []drawAssignment      `json:"assignments"`
+	Unassigned  []int                 `json:"unassigned"`
+	Violations  []constraintViolation `json:"violations,omitempty"`
+}
+
+// newDrawSeed returns a fresh seed from crypto/rand.
+func newDrawSeed() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// drawRNG turns a seed of any length into a ChaCha8 stream, so the same seed
+// always gives the same draw.
+func drawRNG(seed string) *mrand.Rand {
+	return mrand.New(mrand.NewChaCha8(sha256.Sum256([]byte(seed))))
+}
+
+// runDraw arranges the players according to the strategy and places them
+// into the flights, honouring the pairing constraints where it can. It only
+// depends on its arguments.
+func runDraw(in drawInput, seed string) drawResult {
+	rng := drawRNG(seed)
+	players := append([]drawPlayer(nil), in.Players...)
+	rng.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
+
+	cs := newConstraintSet(in.Constraints)
+
+	// When there are more players than free places, the shuffle decides who
+	// is left out, whatever the strategy. Players fixed to a flight go first.
+	sort.SliceStable(players, func(i, j int) bool {
+		_, fi := cs.fixed[players[i].ID]
+		_, fj := cs.fixed[players[j].ID]
+		return fi && !fj
+	})
+	free := 0
+	for _, f := range in.Flights {
+		free += f.free()
+	}
+	var left []drawPlayer
+	if len(players) > free {
+		players, left = players[:free], players[free:]
+	}
+
+	mode := fillFlights
+	switch in.Strategy {
+	case drawSnake:
+		sortByHandicap(players)
+		mode = snakeFlights
+	case drawGrouped:
+		sortByHandicap(players)
+	case drawMixedGender:
+		// Women first, so dealing round-robin spreads them over all flights
+		sort.SliceStable(players, func(i, j int) bool {
+			return players[i].Gender == "F" && players[j].Gender != "F"
+		})
+		mode = dealFlights
+	}
+
+	ids := make([]int, len(players))
+	for i, p := range players {
+		ids[i] = p.ID
+	}
+	pl := newPlacer(in.Flights, mode, cs)
+	units := cs.units(ids)
+
+	// Units with a fixed flight (or a partner already in one) go in first,
+	// so the rest of the draw can't take their places.
+	var unplaced []int
+	var rest [][]int
+	for _, u := range units {
+		if i := pl.fixedFlight(u); i >= 0 {
+			unplaced = append(unplaced, pl.place(u, i)...)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	for _, u := range rest {
+		unplaced = append(unplaced, pl.place(u, -1)...)
+	}
+
+	res := pl.result()
+	res.Unassigned = append([]int{}, unplaced...)
+	for _, p := range left {
+		res.Unassigned = append(res.Unassigned, p.ID)
+	}
+
+	flightOf := make(map[int]int)
+	for _, f := range in.Flights {
+		for _, id := range f.Players {
+			flightOf[id] = f.ID
+		}
+	}
+	for _, a := range res.Assignments {
+		for _, id := range a.PlayerIDs {
+			flightOf[id] = a.FlightID
+		}
+	}
+	res.Violations = checkConstraints(in.Constraints, flightOf)
+	return res
+}
+
+// sortByHandicap orders players from the lowest handicap. The sort is stable,
+// so equal handicaps keep their shuffled order.
+func sortByHandicap(players []drawPlayer) {
+	sort.SliceStable(players, func(i, j int) bool { return players[i].Handicap < players[j].Handicap })
+}
+
+// How the placer walks over the flights.
+const (
+	fillFlights  = iota // fill each flight before moving to the next
+	dealFlights         // one per flight per round
+	snakeFlights        // like dealFlights, every other round backwards
+)
+
+// placer puts groups of players into flights. Each group goes to the flight
+// the strategy would pick next, unless that breaks a keep-apart rule; it then
+// tries the following flights, and only breaks the rule if nothing else fits.
+type placer struct {
+	flights []drawFlight
+	cs      *constraintSet
+	mode    int
+	pos     int     // position in the dealing sequence
+	free    []int   // free places per flight
+	members [][]int // everyone in the flight, old and new
+	added   [][]int // players placed by this draw
+}
+
+func newPlacer(flights []drawFlight, mode int, cs *constraintSet) *placer {
+	p := &placer{
+		flights: flights,
+		cs:      cs,
+		mode:    mode,
+		free:    make([]int, len(flights)),
+		members: make([][]int, len(flights)),
+		added:   make([][]int, len(flights)),
+	}
+	for i, f := range flights {
+		p.free[i] = f.free()
+		p.members[i] = append([]int{}, f.Players...)
+	}
+	return p
+}
+
+// fixedFlight returns the index of the flight the unit is bound to, either
+// by a fixed constraint or by a partner who already plays there, or -1.
+func (p *placer) fixedFlight(unit []int) int {
+	for _, id := range unit {
+		if flightID, ok := p.cs.fixed[id]; ok {
+			for i, f := range p.flights {
+				if f.ID == flightID {
+					return i
+				}
+			}
+		}
+	}
+	for i, f := range p.flights {
+		for _, id := range f.Players {
+			if p.cs.find(id) == p.cs.find(unit[0]) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// sequence maps a dealing position to a flight index.
+func (p *placer) sequence(pos int) int {
+	n := len(p.flights)
+	round, k := pos/n, pos%n
+	if p.mode == snakeFlights && round%2 == 1 {
+		return n - 1 - k
+	}
+	return k
+}
+
+// candidates returns flight indices in the order the strategy prefers them,
+// with the dealing position of each.
+func (p *placer) candidates() ([]int, []int) {
+	n := len(p.flights)
+	var idx, pos []int
+	if p.mode == fillFlights {
+		for i := 0; i < n; i++ {
+			idx = append(idx, i)
+			pos = append(pos, i)
+		}
+		return idx, pos
+	}
+	seen := make(map[int]bool)
+	for q := p.pos; q < p.pos+2*n; q++ {
+		if i := p.sequence(q); !seen[i] {
+			seen[i] = true
+			idx = append(idx, i)
+			pos = append(pos, q)
+		}
+	}
+	return idx, pos
+}
+
+func (p *placer) put(i int, unit []int) {
+	p.members[i] = append(p.members[i], unit...)
+	p.added[i] = append(p.added[i], unit...)
+	p.free[i] -= len(unit)
+}
+
+// place puts the unit into flight fixed if given and there is room, else
+// where the strategy wants it. A unit that fits nowhere as a whole is split.
+// It returns the players that could not be placed at all.
+func (p *placer) place(unit []int, fixed int) []int {
+	if len(p.flights) == 0 {
+		return unit
+	}
+	if fixed >= 0 && p.free[fixed] >= len(unit) {
+		p.put(fixed, unit)
+		return nil
+	}
+
+	idx, pos := p.candidates()
+	for _, strict := range []bool{true, false} {
+		for k, i := range idx {
+			if p.free[i] < len(unit) || (strict && p.cs.conflicts(unit, p.members[i])) {
+				continue
+			}
+			p.put(i, unit)
+			if p.mode != fillFlights {
+				p.pos = pos[k] + 1
+			}
+			return nil
+		}
+	}
+
+	if len(unit) == 1 {
+		return unit
+	}
+	var unplaced []int
+	for _, id := range unit {
+		unplaced = append(unplaced, p.place([]int{id}, -1)...)
+	}
+	return unplaced
+}
+
+func (p *placer) result() drawResult {
+	res := drawResult{Assignments: []drawAssignment{}}
+	for i, f := range p.flights {
+		if len(p.added[i]) > 0 {
+			res.Assignments = append(res.Assignments, drawAssignment{FlightID: f.ID, PlayerIDs: p.added[i]})
+		}
+	}
+	return res
+}
+
+func loadDrawInput(strategy string) (drawInput, error) {
+	in := drawInput{Strategy: strategy, Players: []drawPlayer{}, Flights: []drawFlight{}}
+
+	// Frozen handicaps win over live ones, same as in the results
+	rows, err := db.DB.Query(`
+		SELECT p.id, COALESCE(hs.handicap_index, p.handicap, 0), COALESCE(p.gender, 'M')
+		FROM players p
+		LEFT JOIN handicap_snapshots hs ON hs.player_id = p.id
+		WHERE p.id NOT IN (SELECT player_id FROM flight_players)
+		ORDER BY p.id
+	`)
+	if err != nil {
+		return in, err
+	}
+	for rows.Next() {
+		var p drawPlayer
+		if err := rows.Scan(&p.ID, &p.Handicap, &p.Gender); err != nil {
+			rows.Close()
+			return in, err
+		}
+		in.Players = append(in.Players, p)
+	}
+	rows.Close()
+
+	flights, err := loadFlights()
+	if err != nil {
+		return in, err
+	}
+	for _, f := range flights {
+		df := drawFlight{ID: f.ID, Taken: len(f.Players), Capacity: f.Capacity}
+		for _, p := range f.Players {
+			df.Players = append(df.Players, p.ID)
+		}
+		in.Flights = append(in.Flights, df)
+	}
+
+	constraints, err := loadConstraints()
+	if err != nil {
+		return in, err
+	}
+	if len(constraints) > 0 {
+		in.Constraints = constraints
+	}
+	return in, nil
+}
+
+// saveDraw writes the assignment and its audit record.
+func saveDraw(tx *sql.Tx, seed string, in drawInput, res drawResult) (int64, error) {
+	inputs, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	pairings, err := json.Marshal(res)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, a := range res.Assignments {
+		for _, pID := range a.PlayerIDs {
+			if _, err := tx.Exec("INSERT INTO flight_players (flight_id, player_id) VALUES (?, ?)", a.FlightID, pID); err != nil {
+				return 0, err
+			}
+		}
+	}
+	dbRes, err := tx.Exec("INSERT INTO draws (seed, inputs, pairings) VALUES (?, ?, ?)", seed, string(inputs), string(pairings))
+	if err != nil {
+		return 0, err
+	}
+	return dbRes.LastInsertId()
+}
+
+// RandomAssignHandler draws the unassigned players into the free places.
+// With "auto_create": true it first creates as many flights as are needed
+// for everyone to get a place. With "preview": true nothing is written;
+// committing afterwards with the returned seed and the same options gives
+// the same flights.
+func RandomAssignHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	// Body is optional; an explicit seed makes the draw reproducible
+	var req struct {
+		Seed       string `json:"seed"`
+		Strategy   string `json:"strategy"`
+		Preview    bool   `json:"preview"`
+		AutoCreate bool   `json:"auto_create"`
+		Start      string `json:"start"` // tee_times or shotgun, for auto-created flights
+		Time       string `json:"time"`  // shotgun time, defaults to the field's
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Strategy {
+	case "":
+		req.Strategy = drawRandom
+	case drawRandom, drawSnake, drawGrouped, drawMixedGender:
+	default:
+		http.Error(w, "Unknown strategy: "+req.Strategy, http.StatusBadRequest)
+		return
+	}
+	switch req.Start {
+	case "":
+		req.Start = startTeeTimes
+	case startTeeTimes, startShotgun:
+	default:
+		http.Error(w, "Unknown start: "+req.Start, http.StatusBadRequest)
+		return
+	}
+	if req.Time != "" {
+		t, err := time.Parse(teeTimeLayout, req.Time)
+		if err != nil {
+			http.Error(w, "Invalid time, expected HH:MM", http.StatusBadRequest)
+			return
+		}
+		req.Time = t.Format(teeTimeLayout)
+	}
+
+	in, err := loadDrawInput(req.Strategy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(in.Players) == 0 {
+		json.NewEncoder(w).Encode(drawResult{Assignments: []drawAssignment{}, Unassigned: []int{}})
+		return
+	}
+
+	seed := req.Seed
+	if seed == "" {
+		if seed, err = newDrawSeed(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var plan []plannedFlight
+	var moved []shotgunSlot
+	if req.AutoCreate {
+		free := 0
+		for _, f := range in.Flights {
+			free += f.free()
+		}
+		if plan, moved, err = planFlights(len(in.Players)-free, req.Start, req.Time); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Preview {
+		in.Flights = append(in.Flights, previewFlights(plan)...)
+		res := runDraw(in, seed)
+		flights, err := describeDraw(in, res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"preview":     true,
+			"seed":        seed,
+			"strategy":    req.Strategy,
+			"new_flights": plan,
+			"moved":       moved,
+			"assignments": res.Assignments,
+			"unassigned":  res.Unassigned,
+			"violations":  res.Violations,
+			"flights":     flights,
+		})
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created, err := createPlannedFlights(tx, plan)
+	if err == nil && len(moved) > 0 {
+		err = applyShotgunSlots(tx, moved, plan[0].TeeTime)
+	}
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	in.Flights = append(in.Flights, created...)
+
+	res := runDraw(in, seed)
+	drawID, err := saveDraw(tx, seed, in, res)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flights, err := describeDraw(in, res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The draw is published - freeze handicaps
+	if err := ensureHandicapSnapshots(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draw_id":     drawID,
+		"seed":        seed,
+		"strategy":    req.Strategy,
+		"new_flights": plan,
+		"assignments": res.Assignments,
+		"unassigned":  res.Unassigned,
+		"violations":  res.Violations,
+		"flights":     flights,
+	})
+}
+
+// describeDraw resolves a draw result into flights with player details, so
+// a preview can be shown without another round trip. Handicaps are the ones
+// the draw used, frozen where there is a snapshot.
+func describeDraw(in drawInput, res drawResult) ([]map[string]interface{}, error) {
+	players, err := loadPlayerMap()
+	if err != nil {
+		return nil, err
+	}
+	for _, dp := range in.Players {
+		if p, ok := players[dp.ID]; ok {
+			p.Handicap = dp.Handicap
+			players[dp.ID] = p
+		}
+	}
+
+	flights := []map[string]interface{}{}
+	for _, a := range res.Assignments {
+		list := []models.Player{}
+		total := 0.0
+		for _, id := range a.PlayerIDs {
+			list = append(list, players[id])
+			total += players[id].Handicap
+		}
+		flights = append(flights, map[string]interface{}{
+			"flight_id":        a.FlightID,
+			"players":          list,
+			"average_handicap": math.Round(total/float64(len(list))*10) / 10,
+		})
+	}
+	return flights, nil
+}
+
+// loadPlayerMap returns all players keyed by ID.
+func loadPlayerMap() (map[int]models.Player, error) {
+	rows, err := db.DB.Query("SELECT id, name, surname, reg_num, COALESCE(handicap, 0), COALESCE(gender, 'M') FROM players")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := make(map[int]models.Player)
+	for rows.Next() {
+		var p models.Player
+		if err := rows.Scan(&p.ID, &p.Name, &p.Surname, &p.RegNum, &p.Handicap, &p.Gender); err != nil {
+			return nil, err
+		}
+		players[p.ID] = p
+	}
+	return players, rows.Err()
+}
+
+func loadDraw(id int) (models.Draw, error) {
+	var d models.Draw
+	var inputs, pairings string
+	err := db.DB.QueryRow("SELECT id, created_at, seed, inputs, pairings FROM draws WHERE id = ?", id).
+		Scan(&d.ID, &d.CreatedAt, &d.Seed, &inputs, &pairings)
+	d.Inputs = json.RawMessage(inputs)
+	d.Pairings = json.RawMessage(pairings)
+	return d, err
+}
+
+// DrawsHandler lists all draw records, or a single one with ?id=.
+func DrawsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		id, _ := strconv.Atoi(idStr)
+		d, err := loadDraw(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Draw not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(d)
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT id, created_at, seed, inputs, pairings FROM draws ORDER BY id DESC")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	draws := []models.Draw{}
+	for rows.Next() {
+		var d models.Draw
+		var inputs, pairings string
+		if err := rows.Scan(&d.ID, &d.CreatedAt, &d.Seed, &inputs, &pairings); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.Inputs = json.RawMessage(inputs)
+		d.Pairings = json.RawMessage(pairings)
+		draws = append(draws, d)
+	}
+	json.NewEncoder(w).Encode(draws)
+}
+
+// decodeDrawInput reads the inputs stored with a draw. Draws recorded before
+// the strategies kept only the IDs of the players, as player_ids.
+func decodeDrawInput(data []byte) (drawInput, error) {
+	var in struct {
+		drawInput
+		PlayerIDs []int `json:"player_ids"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return drawInput{}, err
+	}
+	for _, id := range in.PlayerIDs {
+		in.Players = append(in.Players, drawPlayer{ID: id})
+	}
+	return in.drawInput, nil
+}
+
+// ReplayDrawHandler re-runs a recorded draw from its stored seed and inputs
+// and reports whether it gives the same pairings. Nothing is written.
+func ReplayDrawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := loadDraw(req.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Draw not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	in, err := decodeDrawInput(d.Inputs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Round-trip the stored pairings so both sides are encoded the same way
+	var stored drawResult
+	if err := json.Unmarshal(d.Pairings, &stored); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	storedJSON, _ := json.Marshal(stored)
+
+	res := runDraw(in, d.Seed)
+	replayed, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draw_id":  d.ID,
+		"seed":     d.Seed,
+		"matches":  bytes.Equal(replayed, storedJSON),
+		"pairings": res,
+	})
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// handicapParams holds everything needed to turn a handicap index into a
+// playing handicap. Ratings default to par/113 so that an unconfigured course
+// gives a playing handicap equal to the rounded index.
+type handicapParams struct {
+	Par         int
+	RatingM     float64
+	SlopeM      float64
+	RatingF     float64
+	SlopeF      float64
+	AllowancePc float64
+	Rated       bool // any rating, slope or allowance is set
+}
+
+func loadHandicapParams() (handicapParams, error) {
+	var par int
+	if err := db.DB.QueryRow("SELECT COALESCE(SUM(par), 0) FROM holes").Scan(&par); err != nil {
+		return handicapParams{}, err
+	}
+	hp := handicapParams{
+		Par:         par,
+		RatingM:     getSettingFloat("course_rating_yellow", float64(par)),
+		SlopeM:      getSettingFloat("slope_rating_yellow", 113),
+		RatingF:     getSettingFloat("course_rating_red", float64(par)),
+		SlopeF:      getSettingFloat("slope_rating_red", 113),
+		AllowancePc: getSettingFloat("handicap_allowance", 100),
+	}
+	for _, key := range []string{"course_rating_yellow", "slope_rating_yellow", "course_rating_red", "slope_rating_red", "handicap_allowance"} {
+		if getSetting(key, "") != "" {
+			hp.Rated = true
+		}
+	}
+	return hp, nil
+}
+
+// playingHandicap applies the WHS formula. Women play the red tees, everyone
+// else the yellow ones (same rule as the tee lengths in the scoring view).
+func (hp handicapParams) playingHandicap(index float64, gender string) int {
+	rating, slope := hp.RatingM, hp.SlopeM
+	if gender == "F" {
+		rating, slope = hp.RatingF, hp.SlopeF
+	}
+	courseHandicap := index*slope/113 + (rating - float64(hp.Par))
+	return int(math.Round(courseHandicap * hp.AllowancePc / 100))
+}
+
+// loadHandicapSnapshots returns the frozen handicaps keyed by player ID.
+func loadHandicapSnapshots() (map[int]models.HandicapSnapshot, error) {
+	rows, err := db.DB.Query("SELECT player_id, handicap_index, playing_handicap, taken_at FROM handicap_snapshots")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make(map[int]models.HandicapSnapshot)
+	for rows.Next() {
+		var s models.HandicapSnapshot
+		if err := rows.Scan(&s.PlayerID, &s.HandicapIndex, &s.PlayingHandicap, &s.TakenAt); err != nil {
+			return nil, err
+		}
+		snapshots[s.PlayerID] = s
+	}
+	return snapshots, rows.Err()
+}
+
+// ensureHandicapSnapshots freezes the current handicap of every player that
+// has no snapshot yet. It is called when the draw is published, and is a
+// no-op for players already frozen.
+func ensureHandicapSnapshots() error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := snapshotPendingHandicaps(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshotPendingHandicaps is ensureHandicapSnapshots within tx. saveScore
+// calls it once a score is written, so the first saved score freezes the
+// handicaps and a rejected one doesn't.
+func snapshotPendingHandicaps(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT id, COALESCE(handicap, 0), COALESCE(gender, 'M') FROM players
+		WHERE id NOT IN (SELECT player_id FROM handicap_snapshots)
+	`)
+	if err != nil {
+		return err
+	}
+	var pending []models.Player
+	for rows.Next() {
+		var p models.Player
+		if err := rows.Scan(&p.ID, &p.Handicap, &p.Gender); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	params, err := loadHandicapParams()
+	if err != nil {
+		return err
+	}
+	return writeHandicapSnapshots(tx, pending, params)
+}
+
+func writeHandicapSnapshots(tx *sql.Tx, players []models.Player, params handicapParams) error {
+	for _, p := range players {
+		_, err := tx.Exec(`
+			INSERT INTO handicap_snapshots (player_id, handicap_index, playing_handicap, taken_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(player_id) DO UPDATE SET
+				handicap_index = excluded.handicap_index,
+				playing_handicap = excluded.playing_handicap,
+				taken_at = excluded.taken_at
+		`, p.ID, p.Handicap, params.playingHandicap(p.Handicap, p.Gender))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandicapsHandler lists every player's frozen handicap next to the live one,
+// so the admin can see which changes are still pending.
+func HandicapsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := loadHandicapParams()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	snapshots, err := loadHandicapSnapshots()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT id, name, surname, COALESCE(handicap, 0), COALESCE(gender, 'M') FROM players ORDER BY surname, name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type handicapRow struct {
+		PlayerID               int                      `json:"player_id"`
+		Name                   string                   `json:"name"`
+		Surname                string                   `json:"surname"`
+		CurrentIndex           float64                  `json:"current_index"`
+		CurrentPlayingHandicap int                      `json:"current_playing_handicap"`
+		Snapshot               *models.HandicapSnapshot `json:"snapshot"`
+		Pending                bool                     `json:"pending"`
+		PendingIndexDiff       float64                  `json:"pending_index_diff"`
+		PendingPlayingDiff     int                      `json:"pending_playing_diff"`
+	}
+	list := []handicapRow{}
+	for rows.Next() {
+		var hr handicapRow
+		var gender string
+		if err := rows.Scan(&hr.PlayerID, &hr.Name, &hr.Surname, &hr.CurrentIndex, &gender); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hr.CurrentPlayingHandicap = params.playingHandicap(hr.CurrentIndex, gender)
+		if s, ok := snapshots[hr.PlayerID]; ok {
+			hr.Snapshot = &s
+			hr.PendingIndexDiff = math.Round((hr.CurrentIndex-s.HandicapIndex)*10) / 10
+			hr.PendingPlayingDiff = hr.CurrentPlayingHandicap - s.PlayingHandicap
+			hr.Pending = hr.PendingIndexDiff != 0 || hr.PendingPlayingDiff != 0
+		}
+		list = append(list, hr)
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// SnapshotHandicapsHandler freezes handicaps for all players not frozen yet.
+func SnapshotHandicapsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ensureHandicapSnapshots(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ApplyHandicapsHandler copies the live handicap into the snapshot for the
+// given players (or everyone with "all": true). This is the only way a handicap
+// change reaches the results once play has started.
+func ApplyHandicapsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	var req struct {
+		PlayerIDs []int `json:"player_ids"`
+		All       bool  `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params, err := loadHandicapParams()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := "SELECT id, COALESCE(handicap, 0), COALESCE(gender, 'M') FROM players"
+	var rows *sql.Rows
+	if req.All {
+		rows, err = db.DB.Query(query)
+	} else {
+		if len(req.PlayerIDs) == 0 {
+			http.Error(w, "Missing player_ids", http.StatusBadRequest)
+			return
+		}
+		args := make([]interface{}, len(req.PlayerIDs))
+		placeholders := ""
+		for i, id := range req.PlayerIDs {
+			if i > 0 {
+				placeholders += ", "
+			}
+			placeholders += "?"
+			args[i] = id
+		}
+		rows, err = db.DB.Query(query+" WHERE id IN ("+placeholders+")", args...)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var players []models.Player
+	for rows.Next() {
+		var p models.Player
+		if err := rows.Scan(&p.ID, &p.Handicap, &p.Gender); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		players = append(players, p)
+	}
+	rows.Close()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := writeHandicapSnapshots(tx, players, params); err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"applied": len(players)})
+}
+
+func getSettingFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(getSetting(key, ""), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
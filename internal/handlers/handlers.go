@@ -1,14 +1,14 @@
 package handlers
 
 import (
-	"crypto/sha256"
 	"database/sql"
 	"encoding/csv"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"sort"
@@ -148,86 +148,113 @@ func ImportPlayersHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func FlightsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		// Get all flights with players
-		rows, err := db.DB.Query(`
-			SELECT f.id, f.token, f.name, f.starting_hole, p.id, p.name, p.surname, p.reg_num, p.handicap, p.gender
-			FROM flights f
-			LEFT JOIN flight_players fp ON f.id = fp.flight_id
-			LEFT JOIN players p ON fp.player_id = p.id
-		`)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
+// flightWithPlayers is a flight as returned by the API, players included.
+type flightWithPlayers struct {
+	models.Flight
+	Capacity   int             `json:"capacity"`    // effective max players
+	FillStatus string          `json:"fill_status"` // under, full or over
+	ScoreURL   string          `json:"score_url,omitempty"`
+	Players    []models.Player `json:"players"`
+}
 
-		flightMap := make(map[int]*struct {
-			ID           int             `json:"id"`
-			Token        string          `json:"token"`
-			Name         string          `json:"name"`
-			StartingHole int             `json:"starting_hole"`
-			Players      []models.Player `json:"players"`
-		})
+// loadFlights returns all flights with their players, ordered by flight ID.
+func loadFlights() ([]*flightWithPlayers, error) {
+	defaultSize := defaultFlightSize()
 
-		for rows.Next() {
-			var fID, fStartingHole int
-			var fToken, fName string
-			var pID sql.NullInt64
-			var pName, pSurname, pRegNum, pGender sql.NullString
-			var pHandicap sql.NullFloat64
+	rows, err := db.DB.Query(`
+		SELECT f.id, f.token, f.name, f.starting_hole, COALESCE(f.starting_suffix, ''), COALESCE(f.tee_time, ''), COALESCE(f.tee_time_manual, 0), COALESCE(f.max_players, 0),
+			p.id, p.name, p.surname, p.reg_num, p.handicap, p.gender
+		FROM flights f
+		LEFT JOIN flight_players fp ON f.id = fp.flight_id
+		LEFT JOIN players p ON fp.player_id = p.id
+		ORDER BY f.id, fp.rowid
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-			if err := rows.Scan(&fID, &fToken, &fName, &fStartingHole, &pID, &pName, &pSurname, &pRegNum, &pHandicap, &pGender); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+	var flights []*flightWithPlayers
+	flightMap := make(map[int]*flightWithPlayers)
 
-			if _, ok := flightMap[fID]; !ok {
-				flightMap[fID] = &struct {
-					ID           int             `json:"id"`
-					Token        string          `json:"token"`
-					Name         string          `json:"name"`
-					StartingHole int             `json:"starting_hole"`
-					Players      []models.Player `json:"players"`
-				}{
-					ID:           fID,
-					Token:        fToken,
-					Name:         fName,
-					StartingHole: fStartingHole,
-					Players:      []models.Player{},
-				}
-			}
+	for rows.Next() {
+		var f models.Flight
+		var pID sql.NullInt64
+		var pName, pSurname, pRegNum, pGender sql.NullString
+		var pHandicap sql.NullFloat64
 
-			if pID.Valid {
-				flightMap[fID].Players = append(flightMap[fID].Players, models.Player{
-					ID:       int(pID.Int64),
-					Name:     pName.String,
-					Surname:  pSurname.String,
-					RegNum:   pRegNum.String,
-					Handicap: pHandicap.Float64,
-					Gender:   pGender.String,
-				})
-			}
+		if err := rows.Scan(&f.ID, &f.Token, &f.Name, &f.StartingHole, &f.StartingSuffix, &f.TeeTime, &f.TeeTimeManual, &f.MaxPlayers, &pID, &pName, &pSurname, &pRegNum, &pHandicap, &pGender); err != nil {
+			return nil, err
 		}
 
-		var keys []int
-		for k := range flightMap {
-			keys = append(keys, k)
+		if _, ok := flightMap[f.ID]; !ok {
+			flightMap[f.ID] = &flightWithPlayers{Flight: f, Capacity: flightCapacity(f.MaxPlayers, defaultSize), Players: []models.Player{}}
+			flights = append(flights, flightMap[f.ID])
 		}
-		sort.Ints(keys)
 
-		var flights []interface{}
-		for _, k := range keys {
-			flights = append(flights, flightMap[k])
+		if pID.Valid {
+			flightMap[f.ID].Players = append(flightMap[f.ID].Players, models.Player{
+				ID:       int(pID.Int64),
+				Name:     pName.String,
+				Surname:  pSurname.String,
+				RegNum:   pRegNum.String,
+				Handicap: pHandicap.Float64,
+				Gender:   pGender.String,
+			})
 		}
+	}
+
+	for _, f := range flights {
+		switch {
+		case len(f.Players) < f.Capacity:
+			f.FillStatus = "under"
+		case len(f.Players) > f.Capacity:
+			f.FillStatus = "over"
+		default:
+			f.FillStatus = "full"
+		}
+	}
+	return flights, rows.Err()
+}
+
+// defaultFlightSize is the tournament-wide max players per flight.
+func defaultFlightSize() int {
+	n, err := strconv.Atoi(getSetting("max_flight_size", "4"))
+	if err != nil || n < 1 {
+		return 4
+	}
+	return n
+}
+
+// flightCapacity applies a flight's own limit over the tournament default.
+func flightCapacity(maxPlayers, defaultSize int) int {
+	if maxPlayers > 0 {
+		return maxPlayers
+	}
+	return defaultSize
+}
+
+func FlightsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Get all flights with players
+		flights, err := loadFlights()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hideTokens(r, flights)
 		json.NewEncoder(w).Encode(flights)
 
 	} else if r.Method == http.MethodPost {
-		// Create new flight
+		// Create new flight; the response carries its token
+		if !isAdmin(r) {
+			tokenError(w, errAdminRequired)
+			return
+		}
 		var req struct {
 			Name         string `json:"name"`
 			StartingHole int    `json:"starting_hole"`
+			MaxPlayers   int    `json:"max_players"` // 0 = tournament default
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -238,11 +265,18 @@ func FlightsHandler(w http.ResponseWriter, r *http.Request) {
 			req.StartingHole = 1
 		}
 
-		// Generate a hash token
-		hash := sha256.Sum256([]byte(req.Name + time.Now().String()))
-		token := hex.EncodeToString(hash[:])[:16] // Take first 16 chars
+		token, err := newFlightToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		res, err := db.DB.Exec("INSERT INTO flights (token, name, starting_hole) VALUES (?, ?, ?)", token, req.Name, req.StartingHole)
+		if req.MaxPlayers < 0 {
+			http.Error(w, "Invalid max_players", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.DB.Exec("INSERT INTO flights (token, name, starting_hole, max_players) VALUES (?, ?, ?, ?)", token, req.Name, req.StartingHole, req.MaxPlayers)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -251,6 +285,10 @@ func FlightsHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "token": token, "starting_hole": req.StartingHole})
 	} else if r.Method == http.MethodDelete {
 		// Delete flight
+		if !isAdmin(r) {
+			tokenError(w, errAdminRequired)
+			return
+		}
 		var req struct {
 			ID int `json:"id"`
 		}
@@ -295,21 +333,78 @@ func UpdateFlightHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
 	var req struct {
-		ID           int    `json:"id"`
-		Name         string `json:"name"`
-		StartingHole int    `json:"starting_hole"`
+		ID             int     `json:"id"`
+		Name           string  `json:"name"`
+		StartingHole   int     `json:"starting_hole"`
+		StartingSuffix *string `json:"starting_suffix"` // nil = unchanged, cleared when the hole changes
+		TeeTime        *string `json:"tee_time"`        // nil = unchanged, "" = back to generated
+		MaxPlayers     *int    `json:"max_players"`     // nil = unchanged, 0 = tournament default
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err := db.DB.Exec("UPDATE flights SET name = ?, starting_hole = ? WHERE id = ?", req.Name, req.StartingHole, req.ID)
+	// Check everything first, so a bad field leaves the flight untouched
+	var suffix, teeTime string
+	if req.StartingSuffix != nil {
+		suffix = strings.ToUpper(strings.TrimSpace(*req.StartingSuffix))
+		if suffix != "" && suffix != "A" && suffix != "B" {
+			http.Error(w, "starting_suffix must be A, B or empty", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.TeeTime != nil {
+		t, err := parseManualTeeTime(*req.TeeTime)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		teeTime = t
+	}
+	if req.MaxPlayers != nil && *req.MaxPlayers < 0 {
+		http.Error(w, "Invalid max_players", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// A shotgun suffix belongs to the hole, so moving the flight drops it
+	_, err = tx.Exec(`
+		UPDATE flights SET name = ?, starting_hole = ?,
+			starting_suffix = CASE WHEN starting_hole = ? THEN starting_suffix ELSE '' END
+		WHERE id = ?
+	`, req.Name, req.StartingHole, req.StartingHole, req.ID)
+	if err == nil && req.StartingSuffix != nil {
+		_, err = tx.Exec("UPDATE flights SET starting_suffix = ? WHERE id = ?", suffix, req.ID)
+	}
+	if err == nil && req.TeeTime != nil {
+		if teeTime == "" {
+			_, err = tx.Exec("UPDATE flights SET tee_time_manual = 0 WHERE id = ?", req.ID)
+		} else {
+			_, err = tx.Exec("UPDATE flights SET tee_time = ?, tee_time_manual = 1 WHERE id = ?", teeTime, req.ID)
+		}
+	}
+	if err == nil && req.MaxPlayers != nil {
+		_, err = tx.Exec("UPDATE flights SET max_players = ? WHERE id = ?", *req.MaxPlayers, req.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -318,6 +413,10 @@ func AssignPlayerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
 	var req struct {
 		FlightID int `json:"flight_id"`
 		PlayerID int `json:"player_id"`
@@ -327,14 +426,22 @@ func AssignPlayerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if flight is full (max 4)
-	var count int
-	err := db.DB.QueryRow("SELECT COUNT(*) FROM flight_players WHERE flight_id = ?", req.FlightID).Scan(&count)
+	// Check if flight is full (not counting the player if already in it)
+	var count, maxPlayers int
+	err := db.DB.QueryRow("SELECT COALESCE(max_players, 0) FROM flights WHERE id = ?", req.FlightID).Scan(&maxPlayers)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Flight not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM flight_players WHERE flight_id = ? AND player_id != ?", req.FlightID, req.PlayerID).Scan(&count)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if count >= 4 {
+	if count >= flightCapacity(maxPlayers, defaultFlightSize()) {
 		http.Error(w, "Flight is full", http.StatusBadRequest)
 		return
 	}
@@ -375,6 +482,10 @@ func UnassignPlayerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
 	var req struct {
 		PlayerID int `json:"player_id"`
 	}
@@ -391,98 +502,6 @@ func UnassignPlayerHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func RandomAssignHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// 1. Get all unassigned players
-	rows, err := db.DB.Query(`
-		SELECT id FROM players 
-		WHERE id NOT IN (SELECT player_id FROM flight_players)
-	`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var unassignedIds []int
-	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
-			continue
-		}
-		unassignedIds = append(unassignedIds, id)
-	}
-
-	if len(unassignedIds) == 0 {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	// Shuffle unassigned IDs
-	for i := range unassignedIds {
-		j := (int(time.Now().UnixNano()) + i) % len(unassignedIds)
-		unassignedIds[i], unassignedIds[j] = unassignedIds[j], unassignedIds[i]
-	}
-
-	// 2. Get all flights and their current player counts
-	rows, err = db.DB.Query(`
-		SELECT f.id, COUNT(fp.player_id) as count 
-		FROM flights f 
-		LEFT JOIN flight_players fp ON f.id = fp.flight_id 
-		GROUP BY f.id
-	`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	type flightInfo struct {
-		id    int
-		count int
-	}
-	var flights []flightInfo
-	for rows.Next() {
-		var f flightInfo
-		if err := rows.Scan(&f.id, &f.count); err != nil {
-			continue
-		}
-		flights = append(flights, f)
-	}
-
-	// 3. Assign players to flights (max 4 per flight)
-	tx, err := db.DB.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	playerIdx := 0
-	for _, f := range flights {
-		remainingSlots := 4 - f.count
-		for j := 0; j < remainingSlots && playerIdx < len(unassignedIds); j++ {
-			_, err = tx.Exec("INSERT INTO flight_players (flight_id, player_id) VALUES (?, ?)", f.id, unassignedIds[playerIdx])
-			if err != nil {
-				tx.Rollback()
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			playerIdx++
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-}
-
 func ScoresHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		playerIDStr := r.URL.Query().Get("player_id")
@@ -492,6 +511,14 @@ func ScoresHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		playerID, _ := strconv.Atoi(playerIDStr)
 
+		// Reading through a token is limited to that token's flight
+		if requestToken(r) != "" {
+			if _, err := authorizePlayer(r, playerID); err != nil {
+				tokenError(w, err)
+				return
+			}
+		}
+
 		rows, err := db.DB.Query("SELECT hole_number, strokes FROM scores WHERE player_id = ?", playerID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -523,44 +550,87 @@ func ScoresHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Max score 11
-		if s.Strokes > 11 {
-			s.Strokes = 11
+		// Only the player's own flight (or an admin) may write the score
+		auth, err := authorizePlayer(r, s.PlayerID)
+		if err != nil {
+			tokenError(w, err)
+			return
 		}
 
-		// Upsert score (delete existing for this hole/player then insert, or use ON CONFLICT if supported/configured)
-		// Simple way: check if exists
-		var exists int
-		db.DB.QueryRow("SELECT id FROM scores WHERE player_id = ? AND hole_number = ?", s.PlayerID, s.HoleNumber).Scan(&exists)
-
-		if exists > 0 {
-			_, err := db.DB.Exec("UPDATE scores SET strokes = ? WHERE id = ?", s.Strokes, exists)
-			if err != nil {
+		tx, err := db.DB.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		version, err := saveScore(tx, s, newScoreSource(r, auth))
+		var conflict *scoreConflict
+		if errors.As(err, &conflict) {
+			// Keep the recorded conflict, then tell the client what the server has
+			if err := tx.Commit(); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-		} else {
-			_, err := db.DB.Exec("INSERT INTO scores (player_id, hole_number, strokes) VALUES (?, ?, ?)", s.PlayerID, s.HoleNumber, s.Strokes)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(conflict)
+			return
+		}
+		var invalid *validationError
+		if errors.As(err, &invalid) {
+			tx.Rollback()
+			writeValidationError(w, "Invalid score", invalid)
+			return
+		} else if err != nil {
+			tx.Rollback()
+			status := http.StatusInternalServerError
+			if err == errCardLocked {
+				status = http.StatusConflict
 			}
+			http.Error(w, err.Error(), status)
+			return
 		}
-		w.WriteHeader(http.StatusOK)
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{
+			"player_id":   s.PlayerID,
+			"hole_number": s.HoleNumber,
+			"strokes":     s.Strokes,
+			"version":     version,
+		})
 	}
 }
 
-func ResultsHandler(w http.ResponseWriter, r *http.Request) {
+// computeResults works out the leaderboard: every player with gross, net,
+// hole scores and statuses, ranked by net. Served from the leaderboard cache.
+func computeResults() ([]map[string]interface{}, error) {
+	params, err := loadHandicapParams()
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := loadHandicapSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	cards, err := loadScorecards()
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := loadRoundStatuses()
+	if err != nil {
+		return nil, err
+	}
+
 	// 1. Fetch total scores and basic player info
 	rows, err := db.DB.Query(`
-		SELECT p.id, p.name, p.surname, p.handicap, COALESCE(SUM(s.strokes), 0) as total_strokes, COUNT(s.id) as holes_played
+		SELECT p.id, p.name, p.surname, COALESCE(p.handicap, 0), COALESCE(p.gender, 'M'), COALESCE(SUM(s.strokes), 0) as total_strokes, COUNT(s.id) as holes_played
 		FROM players p
 		LEFT JOIN scores s ON p.id = s.player_id
 		GROUP BY p.id
 	`)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -569,24 +639,50 @@ func ResultsHandler(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var pID int
-		var pName, pSurname string
+		var pName, pSurname, pGender string
 		var pHandicap float64
 		var totalStrokes, holesPlayed int
-		if err := rows.Scan(&pID, &pName, &pSurname, &pHandicap, &totalStrokes, &holesPlayed); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		if err := rows.Scan(&pID, &pName, &pSurname, &pHandicap, &pGender, &totalStrokes, &holesPlayed); err != nil {
+			return nil, err
+		}
+
+		// Use the frozen handicap once there is one; live changes stay pending
+		handicap := pHandicap
+		playingHandicap := params.playingHandicap(pHandicap, pGender)
+		pending := 0.0
+		if snap, ok := snapshots[pID]; ok {
+			pending = math.Round((pHandicap-snap.HandicapIndex)*10) / 10
+			handicap = snap.HandicapIndex
+			playingHandicap = snap.PlayingHandicap
 		}
 
-		netScore := float64(totalStrokes) - pHandicap
+		// Net is gross less the handicap index, as it always was; only once
+		// the course is rated does it become gross less the playing handicap
+		netScore := float64(totalStrokes) - handicap
+		if params.Rated {
+			netScore = float64(totalStrokes - playingHandicap)
+		}
+		cardStatus := cards[pID].Status
+		if cardStatus == "" {
+			cardStatus = cardInProgress
+		}
 		res := map[string]interface{}{
-			"id":           pID,
-			"name":         pName,
-			"surname":      pSurname,
-			"handicap":     pHandicap,
-			"gross":        totalStrokes,
-			"net":          netScore,
-			"holes_played": holesPlayed,
-			"scores":       make(map[int]int),
+			"id":               pID,
+			"name":             pName,
+			"surname":          pSurname,
+			"handicap":         handicap,
+			"playing_handicap": playingHandicap,
+			"handicap_pending": pending,
+			"gross":            totalStrokes,
+			"net":              netScore,
+			"holes_played":     holesPlayed,
+			"card_status":      cardStatus,
+			"corrected":        false,
+			"scores":           make(map[int]int),
+		}
+		if rs, ok := statuses[pID]; ok {
+			res["round_status"] = rs.Status
+			res["status_reason"] = rs.Reason
 		}
 		playerMap[pID] = res
 		results = append(results, res)
@@ -606,8 +702,33 @@ func ResultsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// 3. Flag the holes the committee corrected
+	corrRows, err := db.DB.Query("SELECT DISTINCT player_id, hole_number FROM score_history WHERE source = ? ORDER BY player_id, hole_number", sourceCorrection)
+	if err != nil {
+		return nil, err
+	}
+	for corrRows.Next() {
+		var pID, hole int
+		if err := corrRows.Scan(&pID, &hole); err != nil {
+			corrRows.Close()
+			return nil, err
+		}
+		if p, ok := playerMap[pID]; ok {
+			holes, _ := p["corrected_holes"].([]int)
+			p["corrected_holes"] = append(holes, hole)
+			p["corrected"] = true
+		}
+	}
+	corrRows.Close()
+
 	// Sort by Net score
 	sort.Slice(results, func(i, j int) bool {
+		// DNF, DQ, NR and WD go below everybody else
+		_, outI := results[i]["round_status"]
+		_, outJ := results[j]["round_status"]
+		if outI != outJ {
+			return outJ
+		}
 		// Put players with 0 holes at the bottom
 		if results[i]["holes_played"].(int) == 0 && results[j]["holes_played"].(int) > 0 {
 			return false
@@ -618,42 +739,54 @@ func ResultsHandler(w http.ResponseWriter, r *http.Request) {
 		return results[i]["net"].(float64) < results[j]["net"].(float64)
 	})
 
-	json.NewEncoder(w).Encode(results)
+	// Only players still in the round are ranked
+	for i, res := range results {
+		if _, out := res["round_status"]; !out {
+			res["rank"] = i + 1
+		}
+	}
+
+	return results, nil
+}
+
+func ResultsHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := leaderboard.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(state.Results)
+}
+
+// loadHoles returns the course holes in order.
+func loadHoles() ([]models.Hole, error) {
+	rows, err := db.DB.Query("SELECT hole_number, par, length_yellow, length_red, COALESCE(stroke_index, 0) FROM holes ORDER BY hole_number")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holes []models.Hole
+	for rows.Next() {
+		var h models.Hole
+		if err := rows.Scan(&h.HoleNumber, &h.Par, &h.LengthYellow, &h.LengthRed, &h.StrokeIndex); err != nil {
+			return nil, err
+		}
+		holes = append(holes, h)
+	}
+	return holes, rows.Err()
 }
 
 func CourseHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		rows, err := db.DB.Query("SELECT hole_number, par, length_yellow, length_red FROM holes ORDER BY hole_number")
+		holes, err := loadHoles()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		type HoleInfo struct {
-			HoleNumber   int `json:"hole_number"`
-			Par          int `json:"par"`
-			LengthYellow int `json:"length_yellow"`
-			LengthRed    int `json:"length_red"`
-		}
-		var holes []HoleInfo
-		for rows.Next() {
-			var h HoleInfo
-			if err := rows.Scan(&h.HoleNumber, &h.Par, &h.LengthYellow, &h.LengthRed); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			holes = append(holes, h)
-		}
 		json.NewEncoder(w).Encode(holes)
 	} else if r.Method == http.MethodPost {
-		type HoleInfo struct {
-			HoleNumber   int `json:"hole_number"`
-			Par          int `json:"par"`
-			LengthYellow int `json:"length_yellow"`
-			LengthRed    int `json:"length_red"`
-		}
-		var holes []HoleInfo
+		var holes []models.Hole
 		if err := json.NewDecoder(r.Body).Decode(&holes); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -666,7 +799,7 @@ func CourseHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, h := range holes {
-			_, err := tx.Exec("UPDATE holes SET par = ?, length_yellow = ?, length_red = ? WHERE hole_number = ?", h.Par, h.LengthYellow, h.LengthRed, h.HoleNumber)
+			_, err := tx.Exec("UPDATE holes SET par = ?, length_yellow = ?, length_red = ?, stroke_index = COALESCE(?, stroke_index) WHERE hole_number = ?", h.Par, h.LengthYellow, h.LengthRed, h.StrokeIndex, h.HoleNumber)
 			if err != nil {
 				tx.Rollback()
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -714,7 +847,7 @@ func ImportCourseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for i, record := range records {
-		// Skip header: Hole, Par, LengthYellow, LengthRed
+		// Skip header: Hole, Par, LengthYellow, LengthRed, StrokeIndex
 		if i == 0 || len(record) < 3 {
 			continue
 		}
@@ -726,12 +859,17 @@ func ImportCourseHandler(w http.ResponseWriter, r *http.Request) {
 		if len(record) > 3 {
 			lengthRed, _ = strconv.Atoi(record[3])
 		}
+		// Without the column the stroke indexes stay as they are
+		var strokeIndex interface{}
+		if len(record) > 4 && strings.TrimSpace(record[4]) != "" {
+			strokeIndex, _ = strconv.Atoi(strings.TrimSpace(record[4]))
+		}
 
 		if hole < 1 || hole > 18 {
 			continue
 		}
 
-		_, err = tx.Exec("UPDATE holes SET par = ?, length_yellow = ?, length_red = ? WHERE hole_number = ?", par, lengthYellow, lengthRed, hole)
+		_, err = tx.Exec("UPDATE holes SET par = ?, length_yellow = ?, length_red = ?, stroke_index = COALESCE(?, stroke_index) WHERE hole_number = ?", par, lengthYellow, lengthRed, strokeIndex, hole)
 		if err != nil {
 			tx.Rollback()
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -748,7 +886,7 @@ func ImportCourseHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func ExportCourseHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT hole_number, par, length_yellow, length_red FROM holes ORDER BY hole_number")
+	rows, err := db.DB.Query("SELECT hole_number, par, length_yellow, length_red, COALESCE(stroke_index, 0) FROM holes ORDER BY hole_number")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -759,11 +897,11 @@ func ExportCourseHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Disposition", "attachment;filename=course_config.csv")
 
 	writer := csv.NewWriter(w)
-	writer.Write([]string{"Hole", "Par", "LengthYellow", "LengthRed"})
+	writer.Write([]string{"Hole", "Par", "LengthYellow", "LengthRed", "StrokeIndex"})
 
 	for rows.Next() {
-		var hole, par, ly, lr int
-		if err := rows.Scan(&hole, &par, &ly, &lr); err != nil {
+		var hole, par, ly, lr, si int
+		if err := rows.Scan(&hole, &par, &ly, &lr, &si); err != nil {
 			continue
 		}
 		writer.Write([]string{
@@ -771,6 +909,7 @@ func ExportCourseHandler(w http.ResponseWriter, r *http.Request) {
 			strconv.Itoa(par),
 			strconv.Itoa(ly),
 			strconv.Itoa(lr),
+			strconv.Itoa(si),
 		})
 	}
 	writer.Flush()
@@ -898,7 +1037,13 @@ func fetchSingleHCP(client *http.Client, regNum, surname string) (float64, error
 	return 0, fmt.Errorf("failed after retries")
 }
 
+// SettingsHandler returns all settings (GET) or changes some (POST, admin
+// only: settings close scoring, expire tokens and change the handicaps).
 func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
 	if r.Method == http.MethodGet {
 		rows, err := db.DB.Query("SELECT key, value FROM settings")
 		if err != nil {
@@ -922,6 +1067,15 @@ func SettingsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Remember when scoring was closed; token expiry counts from there
+		if v, ok := req["scoring_enabled"]; ok {
+			if v == "0" && getSetting("scoring_closed_at", "") == "" {
+				req["scoring_closed_at"] = time.Now().UTC().Format(time.RFC3339)
+			} else if v == "1" {
+				req["scoring_closed_at"] = ""
+			}
+		}
+
 		for k, v := range req {
 			_, err := db.DB.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", k, v)
 			if err != nil {
@@ -941,3 +1095,12 @@ func isScoringEnabled() bool {
 	}
 	return val == "1"
 }
+
+func getSetting(key, def string) string {
+	var val string
+	err := db.DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&val)
+	if err != nil || val == "" {
+		return def
+	}
+	return val
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// leaderboardState is the cached leaderboard, as sent to a client when it
+// connects to the stream.
+type leaderboardState struct {
+	Version        int                      `json:"version"`
+	Results        []map[string]interface{} `json:"results"`
+	ScoringEnabled bool                     `json:"scoring_enabled"`
+}
+
+// leaderboardDelta is what changed between two versions: the rows that are
+// new or different, the players that are gone and the new order of all
+// players by id.
+type leaderboardDelta struct {
+	Version        int                      `json:"version"`
+	Changed        []map[string]interface{} `json:"changed,omitempty"`
+	Removed        []int                    `json:"removed,omitempty"`
+	Order          []int                    `json:"order"`
+	ScoringEnabled *bool                    `json:"scoring_enabled,omitempty"`
+}
+
+// leaderboardCache keeps the leaderboard between changes, so results
+// requests and stream clients don't each hit the database. It is
+// recalculated once per change and the delta goes out to every stream.
+type leaderboardCache struct {
+	mu          sync.Mutex
+	state       *leaderboardState
+	stale       bool
+	rows        map[int]string // each row as JSON, to find what changed
+	subscribers map[chan []byte]bool
+
+	start sync.Once
+	dirty chan struct{}
+}
+
+var leaderboard = &leaderboardCache{
+	subscribers: make(map[chan []byte]bool),
+	dirty:       make(chan struct{}, 1),
+}
+
+// current returns the cached leaderboard, recalculated if it is out of
+// date, so a client reading right after its own write sees it.
+func (c *leaderboardCache) current() (*leaderboardState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.update(); err != nil {
+		return nil, err
+	}
+	return c.state, nil
+}
+
+// changed marks the leaderboard out of date and has it recalculated in the
+// background for the streams. Changes arriving while it is being
+// recalculated are folded into one more recalculation.
+func (c *leaderboardCache) changed() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+	c.start.Do(func() { go c.run() })
+	select {
+	case c.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (c *leaderboardCache) run() {
+	for range c.dirty {
+		c.mu.Lock()
+		if err := c.update(); err != nil {
+			log.Println("leaderboard:", err)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// update recalculates the leaderboard if it is missing or out of date and
+// sends the delta to the streams. Called with mu held.
+func (c *leaderboardCache) update() error {
+	if c.state != nil && !c.stale {
+		return nil
+	}
+	delta, err := c.refresh()
+	if err != nil {
+		return err
+	}
+	c.stale = false
+	if delta != nil {
+		c.broadcast("delta", delta)
+	}
+	return nil
+}
+
+// refresh recalculates the leaderboard and returns the delta to the previous
+// version, nil if nothing a client sees has changed. Called with mu held.
+func (c *leaderboardCache) refresh() (*leaderboardDelta, error) {
+	results, err := computeResults()
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	enabled := isScoringEnabled()
+
+	version := 1
+	if c.state != nil {
+		version = c.state.Version + 1
+	}
+	delta := &leaderboardDelta{Version: version, Order: make([]int, len(results))}
+	rows := make(map[int]string, len(results))
+	for i, res := range results {
+		id := res["id"].(int)
+		b, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		rows[id] = string(b)
+		delta.Order[i] = id
+		if c.rows[id] != rows[id] {
+			delta.Changed = append(delta.Changed, res)
+		}
+	}
+	for id := range c.rows {
+		if _, ok := rows[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+
+	first := c.state == nil
+	if !first && enabled != c.state.ScoringEnabled {
+		delta.ScoringEnabled = &enabled
+	}
+	if !first && delta.Changed == nil && delta.Removed == nil && delta.ScoringEnabled == nil && sameOrder(c.state.Results, delta.Order) {
+		return nil, nil
+	}
+	c.state = &leaderboardState{Version: version, Results: results, ScoringEnabled: enabled}
+	c.rows = rows
+	return delta, nil
+}
+
+func sameOrder(results []map[string]interface{}, order []int) bool {
+	if len(results) != len(order) {
+		return false
+	}
+	for i, res := range results {
+		if res["id"].(int) != order[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// broadcast sends an event to every stream. A client too slow to keep up is
+// dropped; it reconnects and starts again from a full snapshot. Called with
+// mu held.
+func (c *leaderboardCache) broadcast(event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Println("leaderboard:", err)
+		return
+	}
+	msg := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, b))
+	for ch := range c.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a stream and returns it with the snapshot to start
+// from, so no change can slip in between the two.
+func (c *leaderboardCache) subscribe() (chan []byte, *leaderboardState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.update(); err != nil {
+		return nil, nil, err
+	}
+	ch := make(chan []byte, 16)
+	c.subscribers[ch] = true
+	return ch, c.state, nil
+}
+
+func (c *leaderboardCache) unsubscribe(ch chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribers[ch] {
+		delete(c.subscribers, ch)
+		close(ch)
+	}
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// TrackChanges wraps the API so every successful write (anything but GET)
+// marks the leaderboard out of date: scores, statuses, settings, players
+// and the course all end up in the results.
+func TrackChanges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < 400 {
+			leaderboard.changed()
+		}
+	})
+}
+
+// ResultsStreamHandler streams the leaderboard as Server-Sent Events: a
+// "snapshot" event on connect, then a "delta" event after every change.
+func ResultsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch, state, err := leaderboard.subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer leaderboard.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let a proxy hold the events back
+
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "retry: 3000\nevent: snapshot\ndata: %s\n\n", snapshot)
+	flusher.Flush()
+
+	// Comments keep idle connections from being closed on the way
+	ping := time.NewTicker(25 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			w.Write(msg)
+			flusher.Flush()
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// DejaVu is embedded because the core PDF fonts can't print Czech names.
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	fontRegular []byte
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	fontBold []byte
+)
+
+const pdfFont = "DejaVu"
+
+// newPDF creates an A4 document with the embedded fonts registered.
+func newPDF(orientation string) *fpdf.Fpdf {
+	pdf := fpdf.New(orientation, "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(pdfFont, "", fontRegular)
+	pdf.AddUTF8FontFromBytes(pdfFont, "B", fontBold)
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 10)
+	return pdf
+}
+
+// writePDF sends the finished document as a download.
+func writePDF(w http.ResponseWriter, pdf *fpdf.Fpdf, filename string) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename="+filename)
+	w.Write(buf.Bytes())
+}
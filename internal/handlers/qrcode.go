@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/skip2/go-qrcode"
+)
+
+// scoreURL builds the live score-entry link for a token. The public base URL
+// comes from the PUBLIC_BASE_URL environment variable, not from a setting,
+// so nobody can point the printed codes elsewhere through the API.
+func scoreURL(token string) string {
+	base := os.Getenv("PUBLIC_BASE_URL")
+	if base == "" {
+		base = "https://vanoce.jdark.org/"
+	}
+	return strings.TrimRight(base, "/") + "/?t=" + url.QueryEscape(token)
+}
+
+// qrSVG renders content as an SVG QR code of the given size in pixels.
+func qrSVG(content string, size int) (string, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	bitmap := q.Bitmap()
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String(), nil
+}
+
+// FlightQRHandler returns the QR code for a flight's score link as PNG
+// (default) or SVG (?format=svg). Admin only, as the code contains the token.
+func FlightQRHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = 256
+	}
+
+	var token string
+	err = db.DB.QueryRow("SELECT token FROM flights WHERE id = ?", id).Scan(&token)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Flight not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "png":
+		png, err := qrcode.Encode(scoreURL(token), qrcode.Medium, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	case "svg":
+		svg, err := qrSVG(scoreURL(token), size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(svg))
+	default:
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+	}
+}
+
+var qrSheetTemplate = template.Must(template.New("qr-sheet").Parse(`<!DOCTYPE html>
+<html lang="cs">
+<head>
+<meta charset="UTF-8">
+<title>QR kódy flightů</title>
+<style>
+body { font-family: sans-serif; margin: 10mm; }
+.grid { display: grid; grid-template-columns: repeat(3, 1fr); gap: 8mm; }
+.card { border: 1px solid #999; border-radius: 4px; padding: 4mm; text-align: center; break-inside: avoid; }
+.card h2 { margin: 0 0 2mm; font-size: 16pt; }
+.card p { margin: 1mm 0; }
+.url { font-size: 7pt; color: #555; word-break: break-all; }
+</style>
+</head>
+<body>
+<div class="grid">
+{{range .}}<div class="card">
+<h2>{{.Name}}</h2>
+<p>{{if .TeeTime}}Start: <strong>{{.TeeTime}}</strong>, {{end}}jamka <strong>{{.StartingHole}}{{.StartingSuffix}}</strong></p>
+{{.SVG}}
+<p class="url">{{.URL}}</p>
+</div>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// QRSheetHandler returns one printable HTML page with the QR codes of all
+// flights in tee sheet order.
+func QRSheetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	flights, err := loadFlights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sortTeeSheet(flights)
+
+	type card struct {
+		Name           string
+		TeeTime        string
+		StartingHole   int
+		StartingSuffix string
+		URL            string
+		SVG            template.HTML
+	}
+	cards := make([]card, 0, len(flights))
+	for _, f := range flights {
+		link := scoreURL(f.Token)
+		svg, err := qrSVG(link, 180)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cards = append(cards, card{
+			Name:           f.Name,
+			TeeTime:        f.TeeTime,
+			StartingHole:   f.StartingHole,
+			StartingSuffix: f.StartingSuffix,
+			URL:            link,
+			SVG:            template.HTML(svg),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := qrSheetTemplate.Execute(w, cards); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
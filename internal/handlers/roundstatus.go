@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// Round statuses of players who are out of the ranking.
+var roundStatuses = map[string]bool{
+	"DNF": true, // did not finish
+	"DQ":  true, // disqualified
+	"NR":  true, // no return
+	"WD":  true, // withdrawn
+}
+
+// loadRoundStatuses returns the round status of every player who has one.
+func loadRoundStatuses() (map[int]models.RoundStatus, error) {
+	rows, err := db.DB.Query("SELECT player_id, status, COALESCE(reason, ''), COALESCE(set_at, ''), COALESCE(source, '') FROM round_statuses")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	statuses := make(map[int]models.RoundStatus)
+	for rows.Next() {
+		var s models.RoundStatus
+		if err := rows.Scan(&s.PlayerID, &s.Status, &s.Reason, &s.SetAt, &s.Source); err != nil {
+			return nil, err
+		}
+		statuses[s.PlayerID] = s
+	}
+	return statuses, rows.Err()
+}
+
+// RoundStatusHandler lists the round statuses (GET) or sets one (POST
+// {player_id, status, reason}). The flight's scorer can mark a player of
+// the flight; changing or clearing a status (empty status) is for the
+// committee only.
+func RoundStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		statuses, err := loadRoundStatuses()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		list := make([]models.RoundStatus, 0, len(statuses))
+		for _, s := range statuses {
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].PlayerID < list[j].PlayerID })
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		PlayerID int    `json:"player_id"`
+		Status   string `json:"status"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Status = strings.ToUpper(strings.TrimSpace(req.Status))
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	verr := &validationError{}
+	if req.Status != "" && !roundStatuses[req.Status] {
+		verr.add("status", "must be DNF, DQ, NR or WD, or empty to clear")
+	}
+	if req.Status != "" && req.Reason == "" {
+		verr.add("reason", "a reason is required")
+	}
+	if len(verr.Fields) > 0 {
+		writeValidationError(w, "Invalid round status", verr)
+		return
+	}
+
+	auth, err := authorizePlayer(r, req.PlayerID)
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+	statuses, err := loadRoundStatuses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := statuses[req.PlayerID]; ok && !auth.Admin {
+		tokenError(w, errAdminRequired)
+		return
+	}
+
+	if req.Status == "" {
+		if _, err := db.DB.Exec("DELETE FROM round_statuses WHERE player_id = ?", req.PlayerID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	s := models.RoundStatus{
+		PlayerID: req.PlayerID,
+		Status:   req.Status,
+		Reason:   req.Reason,
+		SetAt:    time.Now().UTC().Format(time.RFC3339),
+		Source:   sourceToken,
+	}
+	if auth.Admin {
+		s.Source = sourceAdmin
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO round_statuses (player_id, status, reason, set_at, source) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(player_id) DO UPDATE SET status = excluded.status, reason = excluded.reason,
+			set_at = excluded.set_at, source = excluded.source
+	`, s.PlayerID, s.Status, s.Reason, s.SetAt, s.Source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/antigravity/christmasTournament/internal/models"
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+// strokesReceived spreads a playing handicap over the holes by stroke index:
+// one stroke per round of the course, plus one more on the hardest holes for
+// the remainder. Plus handicaps give strokes back on the easiest holes.
+// Holes without a stroke index get nothing.
+func strokesReceived(playingHandicap, strokeIndex, holeCount int) int {
+	if strokeIndex < 1 || holeCount == 0 {
+		return 0
+	}
+	if playingHandicap >= 0 {
+		n := playingHandicap / holeCount
+		if strokeIndex <= playingHandicap%holeCount {
+			n++
+		}
+		return n
+	}
+	plus := -playingHandicap
+	n := plus / holeCount
+	if strokeIndex > holeCount-plus%holeCount {
+		n++
+	}
+	return -n
+}
+
+// scorecardData is what every card in a print run shares.
+type scorecardData struct {
+	Holes     []models.Hole
+	Snapshots map[int]models.HandicapSnapshot
+	Params    handicapParams
+}
+
+func loadScorecardData() (scorecardData, error) {
+	var d scorecardData
+	var err error
+	if d.Holes, err = loadHoles(); err != nil {
+		return d, err
+	}
+	if d.Snapshots, err = loadHandicapSnapshots(); err != nil {
+		return d, err
+	}
+	d.Params, err = loadHandicapParams()
+	return d, err
+}
+
+// playingHandicap prefers the frozen snapshot, so the card matches the results.
+func (d scorecardData) playingHandicap(p models.Player) int {
+	if s, ok := d.Snapshots[p.ID]; ok {
+		return s.PlayingHandicap
+	}
+	return d.Params.playingHandicap(p.Handicap, p.Gender)
+}
+
+// handicapIndex is the index the playing handicap was worked out from: the
+// frozen one once there is a snapshot.
+func (d scorecardData) handicapIndex(p models.Player) float64 {
+	if s, ok := d.Snapshots[p.ID]; ok {
+		return s.HandicapIndex
+	}
+	return p.Handicap
+}
+
+// scorecardColumn is one column of the card: a hole or a subtotal.
+type scorecardColumn struct {
+	Hole  *models.Hole
+	Label string
+}
+
+// scorecardColumns lays out the holes as front nine, Out, back nine, In and
+// total. Courses with nine holes or fewer just get the total.
+func scorecardColumns(holes []models.Hole) []scorecardColumn {
+	var cols []scorecardColumn
+	for i := range holes {
+		if i == 9 {
+			cols = append(cols, scorecardColumn{Label: "Out"})
+		}
+		cols = append(cols, scorecardColumn{Hole: &holes[i]})
+	}
+	if len(holes) > 9 {
+		cols = append(cols, scorecardColumn{Label: "In"})
+	}
+	return append(cols, scorecardColumn{Label: "Celkem"})
+}
+
+// addScorecard draws one flight's card on a new page.
+func addScorecard(pdf *fpdf.Fpdf, f *flightWithPlayers, d scorecardData) error {
+	pdf.AddPage()
+	left, top, _, _ := pdf.GetMargins()
+	pageW, _ := pdf.GetPageSize()
+
+	// QR code for live entry in the top right corner
+	png, err := qrcode.Encode(scoreURL(f.Token), qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+	qrName := "qr-" + strconv.Itoa(f.ID)
+	opts := fpdf.ImageOptions{ImageType: "PNG"}
+	pdf.RegisterImageOptionsReader(qrName, opts, bytes.NewReader(png))
+	pdf.ImageOptions(qrName, pageW-left-32, top, 32, 32, false, opts, 0, "")
+
+	pdf.SetFont(pdfFont, "B", 20)
+	pdf.CellFormat(0, 10, f.Name, "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "", 12)
+	start := fmt.Sprintf("Startovní jamka: %d%s", f.StartingHole, f.StartingSuffix)
+	if f.TeeTime != "" {
+		start += "    Start: " + f.TeeTime
+	}
+	pdf.CellFormat(0, 7, start, "", 1, "L", false, 0, "")
+	pdf.SetFont(pdfFont, "", 8)
+	pdf.CellFormat(0, 5, "Živé zapisování: naskenujte QR kód", "", 1, "L", false, 0, "")
+	pdf.SetY(top + 38)
+
+	cols := scorecardColumns(d.Holes)
+	labelW := 50.0
+	colW := (pageW - 2*left - labelW) / float64(len(cols))
+
+	// Course rows
+	courseRows := []struct {
+		label string
+		value func(h models.Hole) string
+	}{
+		{"Jamka", func(h models.Hole) string { return strconv.Itoa(h.HoleNumber) }},
+		{"Žlutá (m)", func(h models.Hole) string { return strconv.Itoa(h.LengthYellow) }},
+		{"Červená (m)", func(h models.Hole) string { return strconv.Itoa(h.LengthRed) }},
+		{"Par", func(h models.Hole) string { return strconv.Itoa(h.Par) }},
+		{"HCP index", func(h models.Hole) string {
+			if h.StrokeIndex == 0 {
+				return ""
+			}
+			return strconv.Itoa(h.StrokeIndex)
+		}},
+	}
+	pdf.SetFillColor(27, 77, 62)
+	for i, row := range courseRows {
+		style := ""
+		if i == 0 {
+			style = "B"
+			pdf.SetTextColor(255, 255, 255)
+		}
+		pdf.SetFont(pdfFont, style, 9)
+		pdf.CellFormat(labelW, 7, row.label, "1", 0, "L", i == 0, 0, "")
+		parSum, parTotal := 0, 0
+		for _, c := range cols {
+			text := ""
+			switch {
+			case c.Hole != nil:
+				text = row.value(*c.Hole)
+				parSum += c.Hole.Par
+				parTotal += c.Hole.Par
+			case i == 0:
+				text = c.Label
+			case row.label == "Par" && c.Label == "Celkem":
+				text = strconv.Itoa(parTotal)
+			case row.label == "Par":
+				text = strconv.Itoa(parSum)
+				parSum = 0
+			}
+			pdf.CellFormat(colW, 7, text, "1", 0, "C", i == 0, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	// One row per player, dots for the strokes received on each hole
+	rowH := 12.0
+	for _, p := range f.Players {
+		ph := d.playingHandicap(p)
+		pdf.SetFont(pdfFont, "B", 10)
+		x, y := pdf.GetXY()
+		pdf.CellFormat(labelW, rowH, "", "1", 0, "L", false, 0, "")
+		pdf.Text(x+1.5, y+5, p.Name+" "+p.Surname)
+		pdf.SetFont(pdfFont, "", 8)
+		pdf.Text(x+1.5, y+9.5, "Hrací HCP: "+strconv.Itoa(ph))
+		for _, c := range cols {
+			cx, cy := pdf.GetXY()
+			fill := c.Hole == nil
+			if fill {
+				pdf.SetFillColor(235, 235, 235)
+			}
+			pdf.CellFormat(colW, rowH, "", "1", 0, "C", fill, 0, "")
+			if c.Hole == nil {
+				continue
+			}
+			n := strokesReceived(ph, c.Hole.StrokeIndex, len(d.Holes))
+			for k := 0; k < n; k++ {
+				pdf.Circle(cx+1.8+float64(k)*2, cy+1.8, 0.6, "F")
+			}
+			if n < 0 {
+				pdf.Text(cx+1, cy+3, "+"+strconv.Itoa(-n))
+			}
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(10)
+	pdf.SetFont(pdfFont, "", 9)
+	pdf.CellFormat(90, 6, "Podpis hráče: ..............................................", "", 0, "L", false, 0, "")
+	pdf.CellFormat(90, 6, "Podpis zapisovatele: ......................................", "", 1, "L", false, 0, "")
+	return nil
+}
+
+// ScorecardsHandler returns printable PDF scorecards: one flight with ?id=,
+// otherwise all flights in tee sheet order. Admin only, as the cards carry
+// the QR code with the token.
+func ScorecardsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+
+	flights, err := loadFlights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sortTeeSheet(flights)
+	filename := "scorecards.pdf"
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		id, _ := strconv.Atoi(idStr)
+		var selected []*flightWithPlayers
+		for _, f := range flights {
+			if f.ID == id {
+				selected = append(selected, f)
+			}
+		}
+		if len(selected) == 0 {
+			http.Error(w, "Flight not found", http.StatusNotFound)
+			return
+		}
+		flights = selected
+		filename = fmt.Sprintf("scorecard-%d.pdf", id)
+	}
+
+	d, err := loadScorecardData()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pdf := newPDF("L")
+	for _, f := range flights {
+		if err := addScorecard(pdf, f, d); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writePDF(w, pdf, filename)
+}
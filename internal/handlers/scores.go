@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// Where a score write came from.
+const (
+	sourceToken      = "token"      // score entry through a flight token
+	sourceAdmin      = "admin"      // committee with the admin key
+	sourceImport     = "import"     // paper cards entered afterwards
+	sourceCorrection = "correction" // committee fix, allowed in any state
+)
+
+// scoreSource is who wrote a score, as kept in the history.
+type scoreSource struct {
+	Source    string
+	FlightID  int
+	ClientIP  string
+	UserAgent string
+	Reason    string // required for corrections
+}
+
+func newScoreSource(r *http.Request, auth scoreAuth) scoreSource {
+	src := scoreSource{
+		Source:    sourceToken,
+		FlightID:  auth.FlightID,
+		ClientIP:  clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if auth.Admin {
+		src.Source = sourceAdmin
+	}
+	return src
+}
+
+// clientIP is the address a request came from. X-Forwarded-For is only
+// believed from a proxy listed in TRUSTED_PROXIES (comma separated IPs); the
+// proxy appends the address it saw, so that last entry is the one taken.
+func clientIP(r *http.Request) string {
+	fwd := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if fwd == "" {
+		return r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" && proxy == host {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	return r.RemoteAddr
+}
+
+// saveScore writes one hole score, replacing any earlier one, and records
+// the change in the history. Every score write goes through here. Invalid
+// scores are refused with a *validationError, submitted cards can't be
+// changed until they are reopened, and a write based on an outdated score is
+// refused with a *scoreConflict. Corrections skip the card lock. The first
+// saved score freezes the handicaps. Returns the new version.
+func saveScore(tx *sql.Tx, s models.Score, src scoreSource) (int, error) {
+	if err := validateScore(tx, s); err != nil {
+		return 0, err
+	}
+
+	if src.Source != sourceCorrection {
+		status, err := cardStatus(tx, s.PlayerID)
+		if err != nil {
+			return 0, err
+		}
+		if status != cardInProgress {
+			return 0, errCardLocked
+		}
+	}
+
+	var version int
+	var old sql.NullInt64
+	err := tx.QueryRow("SELECT strokes, COALESCE(version, 1) FROM scores WHERE player_id = ? AND hole_number = ?", s.PlayerID, s.HoleNumber).Scan(&old, &version)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Entering the same number as the server has is never a conflict
+	current := int(old.Int64)
+	stale := (s.BaseVersion != nil && *s.BaseVersion != version) || (s.LastSeen != nil && *s.LastSeen != current)
+	if stale && s.Strokes != current {
+		c := &scoreConflict{PlayerID: s.PlayerID, HoleNumber: s.HoleNumber, ServerStrokes: current, ServerVersion: version, ClientStrokes: s.Strokes}
+		if err := recordConflict(tx, c, src); err != nil {
+			return 0, err
+		}
+		return 0, c
+	}
+
+	// One statement, so concurrent writers can't both insert the hole. Stats
+	// left out of the write keep their earlier values, stats sent as null
+	// are cleared.
+	err = tx.QueryRow(`
+		INSERT INTO scores (player_id, hole_number, strokes, version, putts, fairway_hit, gir, penalties, bunker)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT(player_id, hole_number) DO UPDATE SET strokes = excluded.strokes,
+			version = COALESCE(scores.version, 1) + 1,
+			putts = CASE WHEN ? THEN NULL ELSE COALESCE(excluded.putts, scores.putts) END,
+			fairway_hit = CASE WHEN ? THEN NULL ELSE COALESCE(excluded.fairway_hit, scores.fairway_hit) END,
+			gir = CASE WHEN ? THEN NULL ELSE COALESCE(excluded.gir, scores.gir) END,
+			penalties = CASE WHEN ? THEN NULL ELSE COALESCE(excluded.penalties, scores.penalties) END,
+			bunker = CASE WHEN ? THEN NULL ELSE COALESCE(excluded.bunker, scores.bunker) END
+		RETURNING version
+	`, s.PlayerID, s.HoleNumber, s.Strokes, s.Putts, s.FairwayHit, s.GIR, s.Penalties, s.Bunker,
+		s.Cleared["putts"], s.Cleared["fairway_hit"], s.Cleared["gir"], s.Cleared["penalties"], s.Cleared["bunker"]).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	var flight interface{}
+	if src.FlightID != 0 {
+		flight = src.FlightID
+	}
+	_, err = tx.Exec(`
+		INSERT INTO score_history (player_id, hole_number, old_strokes, new_strokes, changed_at, source, flight_id, client_ip, user_agent, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.PlayerID, s.HoleNumber, old, s.Strokes, time.Now().UTC().Format(time.RFC3339), src.Source, flight, src.ClientIP, src.UserAgent, src.Reason)
+	if err != nil {
+		return 0, err
+	}
+
+	// First saved score means play has started - freeze handicaps
+	if err := snapshotPendingHandicaps(tx); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// batchResult is the outcome of one entry of a batch submission.
+type batchResult struct {
+	PlayerID   int    `json:"player_id"`
+	HoleNumber int    `json:"hole_number"`
+	Strokes    int    `json:"strokes"`
+	Status     string `json:"status"` // saved, rejected or conflict
+	Error      string `json:"error,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	// Set for conflicts: what the server has now
+	ServerStrokes *int `json:"server_strokes,omitempty"`
+	ServerVersion *int `json:"server_version,omitempty"`
+	// Set for invalid entries: which fields failed
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// BatchScoresHandler saves many scores at once, e.g. the whole flight for a
+// hole or a full paper card. Accepted entries are written in one
+// transaction; rejected ones are reported and skipped.
+func BatchScoresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Scores []models.Score `json:"scores"`
+		Import bool           `json:"import"` // paper cards, recorded as import (admin only)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Paper cards are entered by the committee after scoring has closed
+	if !isScoringEnabled() && !(req.Import && isAdmin(r)) {
+		http.Error(w, "Scoring is currently disabled", http.StatusForbidden)
+		return
+	}
+
+	// A bad token fails the whole batch
+	if !isAdmin(r) {
+		if _, err := flightByToken(requestToken(r)); err != nil {
+			tokenError(w, err)
+			return
+		}
+	}
+
+	results := make([]batchResult, len(req.Scores))
+	sources := make([]scoreSource, len(req.Scores))
+	for i, s := range req.Scores {
+		results[i] = batchResult{PlayerID: s.PlayerID, HoleNumber: s.HoleNumber, Strokes: s.Strokes, Status: "saved"}
+		auth, err := authorizePlayer(r, s.PlayerID)
+		if err != nil {
+			if err != errNotInFlight {
+				tokenError(w, err)
+				return
+			}
+			results[i].Status, results[i].Error = "rejected", err.Error()
+			continue
+		}
+		sources[i] = newScoreSource(r, auth)
+		if req.Import && auth.Admin {
+			sources[i].Source = sourceImport
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saved := 0
+	for i, s := range req.Scores {
+		if results[i].Status != "saved" {
+			continue
+		}
+		version, err := saveScore(tx, s, sources[i])
+		var conflict *scoreConflict
+		var invalid *validationError
+		if errors.As(err, &conflict) {
+			results[i].Status, results[i].Error = "conflict", err.Error()
+			results[i].ServerStrokes, results[i].ServerVersion = &conflict.ServerStrokes, &conflict.ServerVersion
+			continue
+		} else if errors.As(err, &invalid) {
+			results[i].Status, results[i].Error, results[i].Fields = "rejected", err.Error(), invalid.Fields
+			continue
+		} else if err == errCardLocked {
+			results[i].Status, results[i].Error = "rejected", err.Error()
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results[i].Version = version
+		saved++
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"saved":    saved,
+		"rejected": len(req.Scores) - saved, // rejected and conflicting entries
+		"results":  results,
+	})
+}
+
+// ScoreHistoryHandler returns every recorded change of one player's scores,
+// grouped by hole, oldest first. Open to the committee and the player's
+// flight; the writers' addresses and browsers only to the committee.
+func ScoreHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	playerID, err := strconv.Atoi(r.URL.Query().Get("player_id"))
+	if err != nil {
+		http.Error(w, "Missing player_id", http.StatusBadRequest)
+		return
+	}
+	auth, err := authorizePlayer(r, playerID)
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, player_id, hole_number, old_strokes, new_strokes, changed_at, source,
+			COALESCE(flight_id, 0), COALESCE(client_ip, ''), COALESCE(user_agent, ''), COALESCE(reason, '')
+		FROM score_history
+		WHERE player_id = ?
+		ORDER BY hole_number, id
+	`, playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := make(map[int][]models.ScoreChange)
+	for rows.Next() {
+		var c models.ScoreChange
+		var old sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.PlayerID, &c.HoleNumber, &old, &c.NewStrokes, &c.ChangedAt, &c.Source, &c.FlightID, &c.ClientIP, &c.UserAgent, &c.Reason); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if old.Valid {
+			v := int(old.Int64)
+			c.OldStrokes = &v
+		}
+		if !auth.Admin {
+			c.ClientIP, c.UserAgent = "", ""
+		}
+		history[c.HoleNumber] = append(history[c.HoleNumber], c)
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
+// CorrectScoreHandler lets the committee fix a score in any state of the
+// tournament: with scoring closed and on submitted or verified cards. A
+// reason is required; the correction goes to the history and the result is
+// flagged as corrected.
+func CorrectScoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	var req struct {
+		PlayerID   int    `json:"player_id"`
+		HoleNumber int    `json:"hole_number"`
+		Strokes    int    `json:"strokes"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		verr := &validationError{}
+		verr.add("reason", "a reason is required")
+		writeValidationError(w, "Invalid correction", verr)
+		return
+	}
+
+	src := newScoreSource(r, scoreAuth{Admin: true})
+	src.Source, src.Reason = sourceCorrection, req.Reason
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s := models.Score{PlayerID: req.PlayerID, HoleNumber: req.HoleNumber, Strokes: req.Strokes}
+	version, err := saveScore(tx, s, src)
+	var invalid *validationError
+	if errors.As(err, &invalid) {
+		tx.Rollback()
+		writeValidationError(w, "Invalid correction", invalid)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int{
+		"player_id":   s.PlayerID,
+		"hole_number": s.HoleNumber,
+		"strokes":     s.Strokes,
+		"version":     version,
+	})
+}
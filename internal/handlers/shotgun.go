@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// shotgunSlot is one flight's place in a shotgun start.
+type shotgunSlot struct {
+	FlightID     int    `json:"flight_id"`
+	Name         string `json:"name"`
+	StartingHole int    `json:"starting_hole"`
+	Suffix       string `json:"starting_suffix"` // "A"/"B" when two flights share the hole
+	Label        string `json:"label"`           // e.g. "5B"
+}
+
+// allocateShotgun gives every flight its own hole, going round the course
+// from the 1st. When there are more flights than holes, the extra flights
+// go out as B groups, on par 5s first, then on the longest remaining holes.
+func allocateShotgun(flights []*flightWithPlayers, holes []models.Hole) ([]shotgunSlot, error) {
+	if len(flights) > 2*len(holes) {
+		return nil, fmt.Errorf("%d flights don't fit on %d holes even with two groups per hole", len(flights), len(holes))
+	}
+
+	doubled := make(map[int]bool)
+	if extra := len(flights) - len(holes); extra > 0 {
+		byPreference := append([]models.Hole(nil), holes...)
+		sort.SliceStable(byPreference, func(i, j int) bool {
+			a, b := byPreference[i], byPreference[j]
+			if (a.Par == 5) != (b.Par == 5) {
+				return a.Par == 5
+			}
+			if a.Par == 5 {
+				return a.HoleNumber < b.HoleNumber
+			}
+			return a.LengthYellow > b.LengthYellow
+		})
+		for _, h := range byPreference[:extra] {
+			doubled[h.HoleNumber] = true
+		}
+	}
+
+	var slots []shotgunSlot
+	idx := 0
+	for _, h := range holes {
+		suffixes := []string{""}
+		if doubled[h.HoleNumber] {
+			suffixes = []string{"A", "B"}
+		}
+		for _, suffix := range suffixes {
+			if idx >= len(flights) {
+				return slots, nil
+			}
+			f := flights[idx]
+			slots = append(slots, shotgunSlot{
+				FlightID:     f.ID,
+				Name:         f.Name,
+				StartingHole: h.HoleNumber,
+				Suffix:       suffix,
+				Label:        strconv.Itoa(h.HoleNumber) + suffix,
+			})
+			idx++
+		}
+	}
+	return slots, nil
+}
+
+// applyShotgunSlots moves flights to their shotgun holes. Everyone goes off
+// at the same time; manual tee times are kept.
+func applyShotgunSlots(tx *sql.Tx, slots []shotgunSlot, startTime string) error {
+	for _, s := range slots {
+		_, err := tx.Exec(`
+			UPDATE flights SET starting_hole = ?, starting_suffix = ?,
+				tee_time = CASE WHEN tee_time_manual = 1 THEN tee_time ELSE ? END
+			WHERE id = ?
+		`, s.StartingHole, s.Suffix, startTime, s.FlightID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShotgunHandler spreads all flights over the course for a shotgun start.
+// With "preview": true it only returns the allocation. Admin only.
+func ShotgunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	var req struct {
+		Preview bool   `json:"preview"`
+		Time    string `json:"time"` // shotgun time, defaults to the first tee time
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	startTime := loadTeeTimeConfig().First.Format(teeTimeLayout)
+	if req.Time != "" {
+		t, err := time.Parse(teeTimeLayout, req.Time)
+		if err != nil {
+			http.Error(w, "Invalid time, expected HH:MM", http.StatusBadRequest)
+			return
+		}
+		startTime = t.Format(teeTimeLayout)
+	}
+
+	flights, err := loadFlights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	holes, err := loadHoles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slots, err := allocateShotgun(flights, holes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !req.Preview {
+		tx, err := db.DB.Begin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := applyShotgunSlots(tx, slots, startTime); err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"preview": req.Preview,
+		"time":    startTime,
+		"slots":   slots,
+	})
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// Scorecard statuses, in the order a card goes through them.
+const (
+	cardInProgress = "in_progress"
+	cardSubmitted  = "submitted" // attested by the marker, on the flight's device
+	cardAccepted   = "accepted"  // accepted by the player, on the same device
+	cardVerified   = "verified"  // checked by the committee
+)
+
+var errCardLocked = errors.New("Scorecard has been submitted")
+
+// loadScorecards returns the card of every player in a flight, plus any
+// stored ones. Without an explicit marker each player is marked by the next
+// player of the flight.
+func loadScorecards() (map[int]models.Scorecard, error) {
+	flights, err := loadFlights()
+	if err != nil {
+		return nil, err
+	}
+	cards := make(map[int]models.Scorecard)
+	for _, f := range flights {
+		for i, p := range f.Players {
+			c := models.Scorecard{PlayerID: p.ID, Status: cardInProgress}
+			if len(f.Players) > 1 {
+				c.MarkerID = f.Players[(i+1)%len(f.Players)].ID
+			}
+			cards[p.ID] = c
+		}
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT player_id, COALESCE(status, 'in_progress'), COALESCE(marker_id, 0),
+			COALESCE(submitted_at, ''), COALESCE(accepted_at, ''), COALESCE(verified_at, '')
+		FROM scorecards
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stored models.Scorecard
+		if err := rows.Scan(&stored.PlayerID, &stored.Status, &stored.MarkerID, &stored.SubmittedAt, &stored.AcceptedAt, &stored.VerifiedAt); err != nil {
+			return nil, err
+		}
+		if stored.MarkerID == 0 {
+			stored.MarkerID = cards[stored.PlayerID].MarkerID
+		}
+		cards[stored.PlayerID] = stored
+	}
+	return cards, rows.Err()
+}
+
+// cardStatus returns the status of a player's card within a transaction.
+func cardStatus(tx *sql.Tx, playerID int) (string, error) {
+	var status string
+	err := tx.QueryRow("SELECT COALESCE(status, 'in_progress') FROM scorecards WHERE player_id = ?", playerID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return cardInProgress, nil
+	}
+	return status, err
+}
+
+// checkCardTransition says whether a card may move from one status to
+// another. Verifying and reopening are for the committee only.
+func checkCardTransition(from, to string, admin bool) error {
+	switch to {
+	case cardSubmitted:
+		if from != cardInProgress {
+			return fmt.Errorf("card is %s, only a card in progress can be submitted", from)
+		}
+	case cardAccepted:
+		if from != cardSubmitted {
+			return fmt.Errorf("card is %s, only a submitted card can be accepted", from)
+		}
+	case cardVerified:
+		if !admin {
+			return errAdminRequired
+		}
+		if from != cardSubmitted && from != cardAccepted {
+			return fmt.Errorf("card is %s, only a submitted card can be verified", from)
+		}
+	case cardInProgress:
+		if !admin {
+			return errAdminRequired
+		}
+		if from == cardInProgress {
+			return fmt.Errorf("card is already in progress")
+		}
+	default:
+		return fmt.Errorf("status must be in_progress, submitted, accepted or verified")
+	}
+	return nil
+}
+
+// CardStatusesHandler lists the sign-off status of all cards.
+func CardStatusesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cards, err := loadScorecards()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]models.Scorecard, 0, len(cards))
+	for _, c := range cards {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PlayerID < list[j].PlayerID })
+	json.NewEncoder(w).Encode(list)
+}
+
+// CardStatusHandler moves a card on: the flight submits (marker) and
+// accepts (player) it, the committee verifies or reopens it. A flight has
+// one token for all its players, so the server can't tell the marker from
+// the player: both steps are made on the flight's device, the marker
+// handing it to the player to accept. Verification by the committee is the
+// check made with a separate credential.
+//
+// Each transition is one conditional write, so of two concurrent requests
+// only one moves the card on; the other gets 409.
+func CardStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		PlayerID int    `json:"player_id"`
+		Status   string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	auth, err := authorizePlayer(r, req.PlayerID)
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+
+	// The marker is stored with the first write, so later flight changes
+	// don't move it
+	cards, err := loadScorecards()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var marker interface{}
+	if c, ok := cards[req.PlayerID]; ok && c.MarkerID != 0 {
+		marker = c.MarkerID
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	from, err := cardStatus(tx, req.PlayerID)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkCardTransition(from, req.Status, auth.Admin); err == errAdminRequired {
+		tx.Rollback()
+		tokenError(w, err)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	var res sql.Result
+	if req.Status == cardInProgress {
+		res, err = tx.Exec(`
+			UPDATE scorecards SET status = ?, submitted_at = '', accepted_at = '', verified_at = ''
+			WHERE player_id = ? AND COALESCE(status, 'in_progress') = ?
+		`, req.Status, req.PlayerID, from)
+	} else {
+		column := map[string]string{
+			cardSubmitted: "submitted_at",
+			cardAccepted:  "accepted_at",
+			cardVerified:  "verified_at",
+		}[req.Status]
+		res, err = tx.Exec(`
+			INSERT INTO scorecards (player_id, status, marker_id, `+column+`) VALUES (?, ?, ?, ?)
+			ON CONFLICT(player_id) DO UPDATE SET status = excluded.status,
+				marker_id = COALESCE(scorecards.marker_id, excluded.marker_id), `+column+` = excluded.`+column+`
+			WHERE COALESCE(scorecards.status, 'in_progress') = ?
+		`, req.PlayerID, req.Status, marker, time.Now().UTC().Format(time.RFC3339), from)
+	}
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		http.Error(w, "Scorecard was changed meanwhile, reload it", http.StatusConflict)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if cards, err = loadScorecards(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(cards[req.PlayerID])
+}
+
+// CardMarkerHandler assigns the marker of a card. The marker has to be
+// another player of the same flight, and the card must still be in progress.
+func CardMarkerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		PlayerID int `json:"player_id"`
+		MarkerID int `json:"marker_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := authorizePlayer(r, req.PlayerID); err != nil {
+		tokenError(w, err)
+		return
+	}
+
+	var sameFlight int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM flight_players a
+		JOIN flight_players b ON a.flight_id = b.flight_id
+		WHERE a.player_id = ? AND b.player_id = ?
+	`, req.PlayerID, req.MarkerID).Scan(&sameFlight)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sameFlight == 0 || req.MarkerID == req.PlayerID {
+		http.Error(w, "Marker must be another player of the same flight", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec(`
+		INSERT INTO scorecards (player_id, status, marker_id) VALUES (?, 'in_progress', ?)
+		ON CONFLICT(player_id) DO UPDATE SET marker_id = excluded.marker_id
+		WHERE COALESCE(scorecards.status, 'in_progress') = 'in_progress'
+	`, req.PlayerID, req.MarkerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, errCardLocked.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
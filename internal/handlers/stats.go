@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+)
+
+// holeStats sums up the hole stats of one player or of the whole field.
+// Holes without a stat entered don't count towards that stat.
+type holeStats struct {
+	HolesPlayed   int     `json:"holes_played"`
+	Putts         int     `json:"putts"`
+	PuttsHoles    int     `json:"putts_holes"`
+	PuttsPerHole  float64 `json:"putts_per_hole"`
+	PuttsPerRound float64 `json:"putts_per_round"` // per hole, scaled to the whole course
+	FairwaysHit   int     `json:"fairways_hit"`
+	Fairways      int     `json:"fairways"`
+	FairwayPct    float64 `json:"fairway_pct"`
+	GIR           int     `json:"gir"`
+	GIRHoles      int     `json:"gir_holes"`
+	GIRPct        float64 `json:"gir_pct"`
+	Penalties     int     `json:"penalties"`
+	Bunkers       int     `json:"bunkers"`
+	// Average strokes by par of the hole
+	ScoringAverage map[int]float64 `json:"scoring_average"`
+
+	strokesByPar map[int]int
+	holesByPar   map[int]int
+}
+
+func newHoleStats() *holeStats {
+	return &holeStats{
+		ScoringAverage: make(map[int]float64),
+		strokesByPar:   make(map[int]int),
+		holesByPar:     make(map[int]int),
+	}
+}
+
+// statRow is one score with its stats, as read by loadStatRows.
+type statRow struct {
+	PlayerID   int
+	HoleNumber int
+	Par        int
+	Strokes    int
+	Putts      sql.NullInt64
+	FairwayHit sql.NullBool
+	GIR        sql.NullBool
+	Penalties  sql.NullInt64
+	Bunker     sql.NullBool
+}
+
+func (st *holeStats) add(r statRow) {
+	st.HolesPlayed++
+	st.strokesByPar[r.Par] += r.Strokes
+	st.holesByPar[r.Par]++
+	if r.Putts.Valid {
+		st.Putts += int(r.Putts.Int64)
+		st.PuttsHoles++
+	}
+	if r.FairwayHit.Valid && r.Par != 3 {
+		st.Fairways++
+		if r.FairwayHit.Bool {
+			st.FairwaysHit++
+		}
+	}
+	if r.GIR.Valid {
+		st.GIRHoles++
+		if r.GIR.Bool {
+			st.GIR++
+		}
+	}
+	if r.Penalties.Valid {
+		st.Penalties += int(r.Penalties.Int64)
+	}
+	if r.Bunker.Valid && r.Bunker.Bool {
+		st.Bunkers++
+	}
+}
+
+// finish works out the averages and percentages, rounded to one decimal.
+func (st *holeStats) finish(courseHoles int) {
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	if st.PuttsHoles > 0 {
+		perHole := float64(st.Putts) / float64(st.PuttsHoles)
+		st.PuttsPerHole = round(perHole)
+		st.PuttsPerRound = round(perHole * float64(courseHoles))
+	}
+	if st.Fairways > 0 {
+		st.FairwayPct = round(100 * float64(st.FairwaysHit) / float64(st.Fairways))
+	}
+	if st.GIRHoles > 0 {
+		st.GIRPct = round(100 * float64(st.GIR) / float64(st.GIRHoles))
+	}
+	for par, n := range st.holesByPar {
+		st.ScoringAverage[par] = round(float64(st.strokesByPar[par]) / float64(n))
+	}
+}
+
+// loadStatRows returns the scores with their stats, of one player or of
+// everybody when playerID is 0.
+func loadStatRows(playerID int) ([]statRow, error) {
+	query := `
+		SELECT s.player_id, s.hole_number, h.par, s.strokes, s.putts, s.fairway_hit, s.gir, s.penalties, s.bunker
+		FROM scores s
+		JOIN holes h ON h.hole_number = s.hole_number`
+	var args []interface{}
+	if playerID != 0 {
+		query += " WHERE s.player_id = ?"
+		args = append(args, playerID)
+	}
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []statRow
+	for rows.Next() {
+		var r statRow
+		if err := rows.Scan(&r.PlayerID, &r.HoleNumber, &r.Par, &r.Strokes, &r.Putts, &r.FairwayHit, &r.GIR, &r.Penalties, &r.Bunker); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func courseHoleCount() (int, error) {
+	var n int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM holes").Scan(&n)
+	return n, err
+}
+
+// PlayerStatsHandler returns the stats of every player who has a score, or
+// of one player with ?player_id=.
+func PlayerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	playerID := 0
+	if idStr := r.URL.Query().Get("player_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			http.Error(w, "Invalid player_id", http.StatusBadRequest)
+			return
+		}
+		playerID = id
+	}
+
+	type playerStats struct {
+		PlayerID int    `json:"player_id"`
+		Name     string `json:"name"`
+		Surname  string `json:"surname"`
+		*holeStats
+	}
+	players := make(map[int]*playerStats)
+	rows, err := db.DB.Query("SELECT id, name, surname FROM players")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		p := &playerStats{holeStats: newHoleStats()}
+		if err := rows.Scan(&p.PlayerID, &p.Name, &p.Surname); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		players[p.PlayerID] = p
+	}
+	rows.Close()
+	if playerID != 0 && players[playerID] == nil {
+		http.Error(w, "Player not found", http.StatusNotFound)
+		return
+	}
+
+	statRows, err := loadStatRows(playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	courseHoles, err := courseHoleCount()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, sr := range statRows {
+		if p, ok := players[sr.PlayerID]; ok {
+			p.add(sr)
+		}
+	}
+
+	if playerID != 0 {
+		p := players[playerID]
+		p.finish(courseHoles)
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+	list := make([]*playerStats, 0, len(players))
+	for _, p := range players {
+		if p.HolesPlayed == 0 {
+			continue
+		}
+		p.finish(courseHoles)
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PlayerID < list[j].PlayerID })
+	json.NewEncoder(w).Encode(list)
+}
+
+// FieldStatsHandler returns the stats of the whole field, plus the scoring
+// average of every hole.
+func FieldStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statRows, err := loadStatRows(0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	courseHoles, err := courseHoleCount()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	field := newHoleStats()
+	players := make(map[int]bool)
+	strokes := make(map[int]int)
+	counts := make(map[int]int)
+	for _, sr := range statRows {
+		field.add(sr)
+		players[sr.PlayerID] = true
+		strokes[sr.HoleNumber] += sr.Strokes
+		counts[sr.HoleNumber]++
+	}
+	field.finish(courseHoles)
+
+	holeAverage := make(map[int]float64)
+	for hole, n := range counts {
+		holeAverage[hole] = math.Round(float64(strokes[hole])/float64(n)*100) / 100
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"players":      len(players),
+		"stats":        field,
+		"hole_average": holeAverage,
+	})
+}
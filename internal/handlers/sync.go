@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// syncEntry is one score captured by the client, possibly while offline.
+type syncEntry struct {
+	Key        string `json:"idempotency_key"`
+	CapturedAt string `json:"captured_at"` // RFC 3339, when the score was entered
+	models.Score
+}
+
+// UnmarshalJSON decodes the entry; the embedded score has its own decoder,
+// which would otherwise leave out the fields of the entry.
+func (e *syncEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.Score); err != nil {
+		return err
+	}
+	var meta struct {
+		Key        string `json:"idempotency_key"`
+		CapturedAt string `json:"captured_at"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	e.Key, e.CapturedAt = meta.Key, meta.CapturedAt
+	return nil
+}
+
+type syncResult struct {
+	Key string `json:"idempotency_key"`
+	batchResult
+	Duplicate bool `json:"duplicate,omitempty"` // already applied by an earlier sync
+}
+
+// SyncHandler takes the queue of a score-entry client that may have been
+// offline. Entries are applied in capture order in one transaction; an
+// entry whose idempotency key was seen before from the same flight is not
+// applied again but answered with its original result. Once scoring is
+// closed only entries captured before the close are applied, the rest are
+// rejected one by one. The response carries the flight's authoritative
+// state so the client can reconcile.
+func SyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := flightByToken(requestToken(r))
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+	var req struct {
+		Entries []syncEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	received := time.Now().UTC()
+	captured := make([]time.Time, len(req.Entries))
+	for i, e := range req.Entries {
+		t, err := time.Parse(time.RFC3339, e.CapturedAt)
+		if err != nil {
+			t = received
+		}
+		captured[i] = t
+	}
+	order := make([]int, len(req.Entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return captured[order[a]].Before(captured[order[b]]) })
+
+	// Scores entered while scoring was still open count, however late they
+	// arrive. Without a close time nothing is let through.
+	scoringOpen, closedAt := isScoringEnabled(), time.Time{}
+	if !scoringOpen {
+		closedAt, _ = time.Parse(time.RFC3339, getSetting("scoring_closed_at", ""))
+	}
+
+	// Authorise first, the checks read outside the transaction
+	results := make([]syncResult, len(req.Entries))
+	sources := make([]scoreSource, len(req.Entries))
+	for i, e := range req.Entries {
+		results[i] = syncResult{Key: e.Key, batchResult: batchResult{PlayerID: e.PlayerID, HoleNumber: e.HoleNumber, Strokes: e.Strokes, Status: "saved"}}
+		if e.Key == "" {
+			results[i].Status, results[i].Error = "rejected", "Missing idempotency_key"
+			continue
+		}
+		if !scoringOpen && !captured[i].Before(closedAt) {
+			results[i].Status, results[i].Error = "rejected", "Scoring is currently disabled"
+			continue
+		}
+		auth, err := authorizePlayer(r, e.PlayerID)
+		if err == errNotInFlight {
+			results[i].Status, results[i].Error = "rejected", err.Error()
+			continue
+		} else if err != nil {
+			tokenError(w, err)
+			return
+		}
+		sources[i] = newScoreSource(r, auth)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Entries of the queue for the same hole build on each other: one based
+	// on the version an earlier entry started from is based on what that
+	// entry saved, so the device doesn't conflict with itself.
+	type holeKey struct{ player, hole int }
+	type chainedVersion struct{ base, saved int }
+	chain := make(map[holeKey]chainedVersion)
+
+	first := make(map[string]int)
+	for _, i := range order {
+		e, res := req.Entries[i], &results[i]
+		if e.Key == "" {
+			continue
+		}
+		if j, ok := first[e.Key]; ok {
+			*res = results[j]
+			res.Duplicate = true
+			continue
+		}
+		first[e.Key] = i
+
+		var status, errMsg string
+		var version int
+		err := tx.QueryRow("SELECT status, COALESCE(error, ''), COALESCE(version, 0) FROM sync_entries WHERE flight_id = ? AND idempotency_key = ?", f.ID, e.Key).Scan(&status, &errMsg, &version)
+		if err == nil {
+			res.Status, res.Error, res.Version, res.Duplicate = status, errMsg, version, true
+			continue
+		} else if err != sql.ErrNoRows {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if res.Status == "saved" {
+			k := holeKey{e.PlayerID, e.HoleNumber}
+			base := 0
+			if e.BaseVersion != nil {
+				base = *e.BaseVersion
+			}
+			if c, ok := chain[k]; ok && e.BaseVersion != nil && base == c.base {
+				saved := c.saved
+				e.BaseVersion = &saved
+			}
+			version, err := saveScore(tx, e.Score, sources[i])
+			var conflict *scoreConflict
+			var invalid *validationError
+			if errors.As(err, &conflict) {
+				res.Status, res.Error = "conflict", err.Error()
+				res.ServerStrokes, res.ServerVersion = &conflict.ServerStrokes, &conflict.ServerVersion
+			} else if errors.As(err, &invalid) {
+				res.Status, res.Error, res.Fields = "rejected", err.Error(), invalid.Fields
+			} else if err == errCardLocked {
+				res.Status, res.Error = "rejected", err.Error()
+			} else if err != nil {
+				tx.Rollback()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else {
+				res.Version = version
+				chain[k] = chainedVersion{base: base, saved: version}
+			}
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO sync_entries (idempotency_key, flight_id, player_id, hole_number, strokes, captured_at, received_at, status, error, version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, e.Key, f.ID, e.PlayerID, e.HoleNumber, e.Strokes, captured[i].Format(time.RFC3339), received.Format(time.RFC3339), res.Status, res.Error, res.Version)
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state, err := loadFlightState(f.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"state":   state,
+	})
+}
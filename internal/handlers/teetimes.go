@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+const teeTimeLayout = "15:04"
+
+// teeTimeConfig describes how tee times are handed out. With TwoTees set,
+// flights go off the 1st and SecondTee in pairs at the same time.
+type teeTimeConfig struct {
+	First     time.Time
+	Interval  time.Duration
+	TwoTees   bool
+	SecondTee int
+}
+
+func loadTeeTimeConfig() teeTimeConfig {
+	first, err := time.Parse(teeTimeLayout, getSetting("first_tee_time", "09:00"))
+	if err != nil {
+		first, _ = time.Parse(teeTimeLayout, "09:00")
+	}
+	interval, err := strconv.Atoi(getSetting("tee_interval", "9"))
+	if err != nil || interval <= 0 {
+		interval = 9
+	}
+	secondTee, err := strconv.Atoi(getSetting("second_tee", "10"))
+	if err != nil || secondTee < 1 {
+		secondTee = 10
+	}
+	return teeTimeConfig{
+		First:     first,
+		Interval:  time.Duration(interval) * time.Minute,
+		TwoTees:   getSetting("two_tee_start", "0") == "1",
+		SecondTee: secondTee,
+	}
+}
+
+// slot returns the tee time and tee for the i-th scheduled flight.
+func (c teeTimeConfig) slot(i int) (string, int) {
+	if c.TwoTees {
+		tee := 1
+		if i%2 == 1 {
+			tee = c.SecondTee
+		}
+		return c.First.Add(time.Duration(i/2) * c.Interval).Format(teeTimeLayout), tee
+	}
+	return c.First.Add(time.Duration(i) * c.Interval).Format(teeTimeLayout), 1
+}
+
+// parseManualTeeTime checks a tee time typed in by hand and returns it as
+// HH:MM. Empty stays empty: the flight goes back to its generated time.
+func parseManualTeeTime(teeTime string) (string, error) {
+	if teeTime == "" {
+		return "", nil
+	}
+	t, err := time.Parse(teeTimeLayout, teeTime)
+	if err != nil {
+		return "", fmt.Errorf("invalid tee_time %q, expected HH:MM", teeTime)
+	}
+	return t.Format(teeTimeLayout), nil
+}
+
+// sortTeeSheet orders flights by tee time; unscheduled flights go last.
+func sortTeeSheet(flights []*flightWithPlayers) {
+	sort.SliceStable(flights, func(i, j int) bool {
+		a, b := flights[i], flights[j]
+		if (a.TeeTime == "") != (b.TeeTime == "") {
+			return b.TeeTime == ""
+		}
+		if a.TeeTime != b.TeeTime {
+			return a.TeeTime < b.TeeTime
+		}
+		if a.StartingHole != b.StartingHole {
+			return a.StartingHole < b.StartingHole
+		}
+		if a.StartingSuffix != b.StartingSuffix {
+			return a.StartingSuffix < b.StartingSuffix
+		}
+		return a.ID < b.ID
+	})
+}
+
+// sortByStartingHole orders flights by starting hole, then tee time.
+func sortByStartingHole(flights []*flightWithPlayers) {
+	sortTeeSheet(flights)
+	sort.SliceStable(flights, func(i, j int) bool {
+		a, b := flights[i], flights[j]
+		if a.StartingHole != b.StartingHole {
+			return a.StartingHole < b.StartingHole
+		}
+		return a.StartingSuffix < b.StartingSuffix
+	})
+}
+
+// playerTee is the tee a player plays from, same rule as everywhere else.
+func playerTee(p models.Player) string {
+	if p.Gender == "F" {
+		return "Červená"
+	}
+	return "Žlutá"
+}
+
+// GenerateTeeTimesHandler assigns tee times to all flights in flight order.
+// Flights with a manual tee time keep it and don't take up a slot. Admin only.
+func GenerateTeeTimesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	var req struct {
+		FirstTeeTime string `json:"first_tee_time"`
+		Interval     int    `json:"interval"`
+		TwoTees      *bool  `json:"two_tees"`
+		SecondTee    int    `json:"second_tee"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Anything sent in the request becomes the new default
+	updates := make(map[string]string)
+	if req.FirstTeeTime != "" {
+		t, err := time.Parse(teeTimeLayout, req.FirstTeeTime)
+		if err != nil {
+			http.Error(w, "Invalid first_tee_time, expected HH:MM", http.StatusBadRequest)
+			return
+		}
+		updates["first_tee_time"] = t.Format(teeTimeLayout)
+	}
+	if req.Interval < 0 {
+		http.Error(w, "Invalid interval", http.StatusBadRequest)
+		return
+	}
+	if req.Interval > 0 {
+		updates["tee_interval"] = strconv.Itoa(req.Interval)
+	}
+	if req.TwoTees != nil {
+		updates["two_tee_start"] = "0"
+		if *req.TwoTees {
+			updates["two_tee_start"] = "1"
+		}
+	}
+	if req.SecondTee > 0 {
+		updates["second_tee"] = strconv.Itoa(req.SecondTee)
+	}
+	for k, v := range updates {
+		if _, err := db.DB.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", k, v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	cfg := loadTeeTimeConfig()
+	flights, err := loadFlights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slot := 0
+	for _, f := range flights {
+		if f.TeeTimeManual {
+			continue
+		}
+		f.TeeTime, f.StartingHole = cfg.slot(slot)
+		slot++
+		if cfg.TwoTees {
+			f.StartingSuffix = ""
+			_, err = tx.Exec("UPDATE flights SET tee_time = ?, starting_hole = ?, starting_suffix = '' WHERE id = ?", f.TeeTime, f.StartingHole, f.ID)
+		} else {
+			_, err = tx.Exec("UPDATE flights SET tee_time = ? WHERE id = ?", f.TeeTime, f.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hideTokens(r, flights)
+	sortTeeSheet(flights)
+	json.NewEncoder(w).Encode(flights)
+}
+
+// TeeSheetHandler returns the starter's tee sheet, ordered by tee time or
+// with ?sort=hole by starting hole. ?format=csv or ?format=pdf gives a
+// printable export with playing handicaps, tees and a check-in column.
+func TeeSheetHandler(w http.ResponseWriter, r *http.Request) {
+	flights, err := loadFlights()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hideTokens(r, flights)
+	if r.URL.Query().Get("sort") == "hole" {
+		sortByStartingHole(flights)
+	} else {
+		sortTeeSheet(flights)
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		json.NewEncoder(w).Encode(flights)
+		return
+	}
+	d, err := loadScorecardData()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch format {
+	case "csv":
+		writeTeeSheetCSV(w, flights, d)
+	case "pdf":
+		writeTeeSheetPDF(w, flights, d)
+	default:
+		http.Error(w, "format must be json, csv or pdf", http.StatusBadRequest)
+	}
+}
+
+func writeTeeSheetCSV(w http.ResponseWriter, flights []*flightWithPlayers, d scorecardData) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment;filename=teesheet.csv")
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"TeeTime", "StartingHole", "Flight", "Player", "Handicap", "PlayingHandicap", "Tee", "CheckIn"})
+	for _, f := range flights {
+		for _, p := range f.Players {
+			writer.Write([]string{
+				f.TeeTime,
+				strconv.Itoa(f.StartingHole) + f.StartingSuffix,
+				f.Name,
+				p.Name + " " + p.Surname,
+				strconv.FormatFloat(d.handicapIndex(p), 'f', 1, 64),
+				strconv.Itoa(d.playingHandicap(p)),
+				playerTee(p),
+				"",
+			})
+		}
+	}
+	writer.Flush()
+}
+
+func writeTeeSheetPDF(w http.ResponseWriter, flights []*flightWithPlayers, d scorecardData) {
+	pdf := newPDF("P")
+	pdf.AddPage()
+	pdf.SetFont(pdfFont, "B", 16)
+	pdf.CellFormat(0, 10, "Startovní listina", "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	widths := []float64{18, 18, 35, 60, 16, 18, 15}
+	headers := []string{"Čas", "Jamka", "Flight", "Hráč", "HCP", "Hrací HCP", "Přítomen"}
+	header := func() {
+		pdf.SetFont(pdfFont, "B", 9)
+		pdf.SetFillColor(27, 77, 62)
+		pdf.SetTextColor(255, 255, 255)
+		for i, h := range headers {
+			pdf.CellFormat(widths[i], 7, h, "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetTextColor(0, 0, 0)
+	}
+	header()
+
+	rowH := 7.0
+	_, pageH := pdf.GetPageSize()
+	for _, f := range flights {
+		rows := max(len(f.Players), 1)
+		// Keep a flight on one page
+		if pdf.GetY()+float64(rows)*rowH > pageH-10 {
+			pdf.AddPage()
+			header()
+		}
+		pdf.SetFont(pdfFont, "", 9)
+		pdf.SetFillColor(240, 240, 240)
+		x, y := pdf.GetXY()
+		h := float64(rows) * rowH
+		pdf.CellFormat(widths[0], h, f.TeeTime, "1", 0, "C", true, 0, "")
+		pdf.CellFormat(widths[1], h, strconv.Itoa(f.StartingHole)+f.StartingSuffix, "1", 0, "C", true, 0, "")
+		pdf.CellFormat(widths[2], h, f.Name, "1", 0, "L", true, 0, "")
+		left := x + widths[0] + widths[1] + widths[2]
+		for i, p := range f.Players {
+			pdf.SetXY(left, y+float64(i)*rowH)
+			pdf.CellFormat(widths[3], rowH, p.Name+" "+p.Surname+" ("+playerTee(p)+")", "1", 0, "L", false, 0, "")
+			pdf.CellFormat(widths[4], rowH, strconv.FormatFloat(d.handicapIndex(p), 'f', 1, 64), "1", 0, "C", false, 0, "")
+			pdf.CellFormat(widths[5], rowH, strconv.Itoa(d.playingHandicap(p)), "1", 0, "C", false, 0, "")
+			cx, cy := pdf.GetXY()
+			pdf.CellFormat(widths[6], rowH, "", "1", 0, "C", false, 0, "")
+			pdf.Rect(cx+widths[6]/2-2, cy+1.5, 4, 4, "D")
+		}
+		if len(f.Players) == 0 {
+			pdf.SetXY(left, y)
+			pdf.CellFormat(widths[3]+widths[4]+widths[5]+widths[6], rowH, "", "1", 0, "L", false, 0, "")
+		}
+		pdf.SetXY(x, y+h)
+	}
+	writePDF(w, pdf, "teesheet.pdf")
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/antigravity/christmasTournament/internal/db"
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+var (
+	errTokenInvalid  = errors.New("Invalid flight token")
+	errTokenExpired  = errors.New("Flight token has expired")
+	errNotInFlight   = errors.New("Player is not in this flight")
+	errAdminRequired = errors.New("Admin credential required")
+)
+
+// isAdmin checks the X-Admin-Key header against the ADMIN_KEY environment
+// variable. Without ADMIN_KEY set nobody is admin.
+func isAdmin(r *http.Request) bool {
+	key := os.Getenv("ADMIN_KEY")
+	given := r.Header.Get("X-Admin-Key")
+	return key != "" && subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1
+}
+
+// scoreAuth says who is writing or reading a score.
+type scoreAuth struct {
+	Admin    bool
+	FlightID int // flight of the token used, 0 for admin
+}
+
+// authorizePlayer lets the request through if it comes from an admin, or
+// carries a valid flight token for the player's flight.
+func authorizePlayer(r *http.Request, playerID int) (scoreAuth, error) {
+	if isAdmin(r) {
+		return scoreAuth{Admin: true}, nil
+	}
+	f, err := flightByToken(requestToken(r))
+	if err != nil {
+		return scoreAuth{}, err
+	}
+	var n int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM flight_players WHERE flight_id = ? AND player_id = ?", f.ID, playerID).Scan(&n); err != nil {
+		return scoreAuth{}, err
+	}
+	if n == 0 {
+		return scoreAuth{}, errNotInFlight
+	}
+	return scoreAuth{FlightID: f.ID}, nil
+}
+
+// hideTokens blanks the flight tokens unless the caller is an admin, so the
+// public flight listings can't be used to collect them. Admins get the score
+// links as well.
+func hideTokens(r *http.Request, flights []*flightWithPlayers) {
+	admin := isAdmin(r)
+	for _, f := range flights {
+		if admin {
+			f.ScoreURL = scoreURL(f.Token)
+		} else {
+			f.Token = ""
+		}
+	}
+}
+
+// newFlightToken generates the access token for a flight: 128 bits from
+// crypto/rand, so it can't be derived from anything about the flight.
+func newFlightToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// tokenExpiry returns when flight tokens stop working. Tokens only expire if
+// token_expiry_hours is set, counted from the moment scoring was closed.
+func tokenExpiry() (time.Time, bool) {
+	hours, err := strconv.ParseFloat(getSetting("token_expiry_hours", ""), 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	closedAt, err := time.Parse(time.RFC3339, getSetting("scoring_closed_at", ""))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return closedAt.Add(time.Duration(hours * float64(time.Hour))), true
+}
+
+// flightByToken resolves a token to its flight and checks it is still valid.
+// Every request authorised by a flight token goes through here.
+func flightByToken(token string) (models.Flight, error) {
+	var f models.Flight
+	if token == "" {
+		return f, errTokenInvalid
+	}
+	err := db.DB.QueryRow("SELECT id, token, name, starting_hole, COALESCE(starting_suffix, ''), COALESCE(tee_time, '') FROM flights WHERE token = ?", token).
+		Scan(&f.ID, &f.Token, &f.Name, &f.StartingHole, &f.StartingSuffix, &f.TeeTime)
+	if err == sql.ErrNoRows {
+		return f, errTokenInvalid
+	} else if err != nil {
+		return f, err
+	}
+	if expiry, ok := tokenExpiry(); ok && time.Now().After(expiry) {
+		return f, errTokenExpired
+	}
+	return f, nil
+}
+
+// requestToken reads the flight token from the X-Flight-Token header or the
+// t/token query parameter (the same names the score links use).
+func requestToken(r *http.Request) string {
+	if t := r.Header.Get("X-Flight-Token"); t != "" {
+		return t
+	}
+	if t := r.URL.Query().Get("t"); t != "" {
+		return t
+	}
+	return r.URL.Query().Get("token")
+}
+
+// tokenError writes the response for a failed token check.
+func tokenError(w http.ResponseWriter, err error) {
+	if err == errTokenInvalid || err == errTokenExpired || err == errNotInFlight || err == errAdminRequired {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// TokenStatusHandler tells the score-entry page whether its token works.
+func TokenStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := flightByToken(requestToken(r))
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+	resp := map[string]interface{}{"valid": true, "flight_id": f.ID}
+	if expiry, ok := tokenExpiry(); ok {
+		resp["expires_at"] = expiry.Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// loadFlightState collects everything the score-entry page needs for a
+// flight: the flight with its players, the course, the scores entered so far
+// with their versions, the state of the cards and the round statuses.
+func loadFlightState(flightID int) (map[string]interface{}, error) {
+	flights, err := loadFlights()
+	if err != nil {
+		return nil, err
+	}
+	var flight *flightWithPlayers
+	for _, fl := range flights {
+		if fl.ID == flightID {
+			flight = fl
+			break
+		}
+	}
+	if flight == nil {
+		return nil, errTokenInvalid
+	}
+
+	holes, err := loadHoles()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT s.player_id, s.hole_number, s.strokes, COALESCE(s.version, 1)
+		FROM scores s
+		JOIN flight_players fp ON fp.player_id = s.player_id
+		WHERE fp.flight_id = ?
+	`, flightID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	// Versions go back with score writes so concurrent edits are noticed
+	scores := make(map[int]map[int]int)
+	versions := make(map[int]map[int]int)
+	for _, p := range flight.Players {
+		scores[p.ID] = make(map[int]int)
+		versions[p.ID] = make(map[int]int)
+	}
+	for rows.Next() {
+		var playerID, hole, strokes, version int
+		if err := rows.Scan(&playerID, &hole, &strokes, &version); err != nil {
+			return nil, err
+		}
+		scores[playerID][hole] = strokes
+		versions[playerID][hole] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	allCards, err := loadScorecards()
+	if err != nil {
+		return nil, err
+	}
+	cards := make(map[int]models.Scorecard)
+	for _, p := range flight.Players {
+		cards[p.ID] = allCards[p.ID]
+	}
+	allStatuses, err := loadRoundStatuses()
+	if err != nil {
+		return nil, err
+	}
+	roundStatuses := make(map[int]models.RoundStatus)
+	for _, p := range flight.Players {
+		if rs, ok := allStatuses[p.ID]; ok {
+			roundStatuses[p.ID] = rs
+		}
+	}
+
+	state := map[string]interface{}{
+		"flight":          flight,
+		"course":          holes,
+		"scores":          scores,
+		"versions":        versions,
+		"cards":           cards,
+		"round_statuses":  roundStatuses,
+		"scoring_enabled": isScoringEnabled(),
+	}
+	if expiry, ok := tokenExpiry(); ok {
+		state["expires_at"] = expiry.Format(time.RFC3339)
+	}
+	return state, nil
+}
+
+// FlightByTokenHandler returns the state of the token's flight.
+func FlightByTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := flightByToken(requestToken(r))
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+	state, err := loadFlightState(f.ID)
+	if err != nil {
+		tokenError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// RotateTokenHandler gives a flight (or with "all": true every flight) a new
+// token. The old one stops working straight away. Admin only, as the
+// response carries the new tokens.
+func RotateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		tokenError(w, errAdminRequired)
+		return
+	}
+	var req struct {
+		ID  int  `json:"id"`
+		All bool `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ids []int
+	if req.All {
+		rows, err := db.DB.Query("SELECT id FROM flights ORDER BY id")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+	} else {
+		ids = []int{req.ID}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tokens := make(map[int]string)
+	for _, id := range ids {
+		token, err := newFlightToken()
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err := tx.Exec("UPDATE flights SET token = ? WHERE id = ?", token, id)
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			tx.Rollback()
+			http.Error(w, "Flight not found", http.StatusNotFound)
+			return
+		}
+		tokens[id] = token
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/antigravity/christmasTournament/internal/models"
+)
+
+// fieldError says which field of a request failed and why.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError collects every failed field of a request.
+type validationError struct {
+	Fields []fieldError `json:"fields"`
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *validationError) add(field, message string) {
+	e.Fields = append(e.Fields, fieldError{Field: field, Message: message})
+}
+
+// maxStrokes is the highest score accepted on a hole, from the max_strokes
+// setting.
+func maxStrokes(tx *sql.Tx) (int, error) {
+	var val string
+	err := tx.QueryRow("SELECT value FROM settings WHERE key = 'max_strokes'").Scan(&val)
+	if err == sql.ErrNoRows {
+		return 11, nil
+	} else if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 1 {
+		return 11, nil
+	}
+	return n, nil
+}
+
+// validateScore checks a score against the tournament: the hole has to be on
+// the course, the strokes between 1 and max_strokes, and the player has to
+// exist and play in a flight. Hole stats, where given, have to fit the
+// strokes. Returns a *validationError listing every failed field.
+func validateScore(tx *sql.Tx, s models.Score) error {
+	verr := &validationError{}
+
+	var par int
+	err := tx.QueryRow("SELECT par FROM holes WHERE hole_number = ?", s.HoleNumber).Scan(&par)
+	if err == sql.ErrNoRows {
+		verr.add("hole_number", fmt.Sprintf("hole %d is not on the course", s.HoleNumber))
+	} else if err != nil {
+		return err
+	}
+
+	max, err := maxStrokes(tx)
+	if err != nil {
+		return err
+	}
+	if s.Strokes < 1 || s.Strokes > max {
+		verr.add("strokes", fmt.Sprintf("must be between 1 and %d", max))
+	}
+	if s.Putts != nil && (*s.Putts < 0 || *s.Putts > s.Strokes) {
+		verr.add("putts", "must be between 0 and the strokes")
+	}
+	if s.Penalties != nil && (*s.Penalties < 0 || *s.Penalties >= s.Strokes) {
+		verr.add("penalties", "must be between 0 and the strokes less one")
+	}
+	if s.Putts != nil && s.Penalties != nil && *s.Putts+*s.Penalties > s.Strokes {
+		verr.add("putts", "putts and penalties are more than the strokes")
+	}
+	if s.FairwayHit != nil && par == 3 {
+		verr.add("fairway_hit", "a par 3 has no fairway to hit")
+	}
+
+	var players, flights int
+	err = tx.QueryRow(`
+		SELECT COUNT(*), (SELECT COUNT(*) FROM flight_players WHERE player_id = ?)
+		FROM players WHERE id = ?
+	`, s.PlayerID, s.PlayerID).Scan(&players, &flights)
+	if err != nil {
+		return err
+	}
+	if players == 0 {
+		verr.add("player_id", fmt.Sprintf("player %d does not exist", s.PlayerID))
+	} else if flights == 0 {
+		verr.add("player_id", "player is not assigned to a flight")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// writeValidationError answers a failed validation with 400, the message and
+// the field errors as JSON.
+func writeValidationError(w http.ResponseWriter, message string, err *validationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  message,
+		"fields": err.Fields,
+	})
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type Player struct {
 	ID       int     `json:"id"`
 	Name     string  `json:"name"`
@@ -10,10 +12,22 @@ type Player struct {
 }
 
 type Flight struct {
-	ID           int    `json:"id"`
-	Token        string `json:"token"`
-	Name         string `json:"name"`
-	StartingHole int    `json:"starting_hole"`
+	ID             int    `json:"id"`
+	Token          string `json:"token,omitempty"`
+	Name           string `json:"name"`
+	StartingHole   int    `json:"starting_hole"`
+	StartingSuffix string `json:"starting_suffix"` // "A"/"B" for two groups on one hole in a shotgun
+	TeeTime        string `json:"tee_time"`        // "HH:MM", empty if not scheduled
+	TeeTimeManual  bool   `json:"tee_time_manual"` // true when set by hand and kept on regeneration
+	MaxPlayers     int    `json:"max_players"`     // 0 = tournament default
+}
+
+type Hole struct {
+	HoleNumber   int `json:"hole_number"`
+	Par          int `json:"par"`
+	LengthYellow int `json:"length_yellow"`
+	LengthRed    int `json:"length_red"`
+	StrokeIndex  int `json:"stroke_index"` // 0 = not set
 }
 
 type FlightPlayer struct {
@@ -26,4 +40,119 @@ type Score struct {
 	PlayerID   int `json:"player_id"`
 	HoleNumber int `json:"hole_number"`
 	Strokes    int `json:"strokes"`
+	// What the client last saw of this score, 0 for an empty hole. Either
+	// one turns on the conflict check; without them the write always wins.
+	BaseVersion *int `json:"base_version,omitempty"`
+	LastSeen    *int `json:"last_seen,omitempty"`
+	// Optional hole stats, left out when not entered
+	Putts      *int  `json:"putts,omitempty"`
+	FairwayHit *bool `json:"fairway_hit,omitempty"` // tee shot on the fairway, not on par 3s
+	GIR        *bool `json:"gir,omitempty"`         // green in regulation
+	Penalties  *int  `json:"penalties,omitempty"`   // penalty strokes, included in strokes
+	Bunker     *bool `json:"bunker,omitempty"`      // played from a bunker
+	// Stats sent as null, to be cleared. Stats left out keep their values.
+	Cleared map[string]bool `json:"-"`
+}
+
+// scoreStats are the JSON names of the optional hole stats.
+var scoreStats = []string{"putts", "fairway_hit", "gir", "penalties", "bunker"}
+
+// UnmarshalJSON decodes a score and notes which stats were sent as null.
+func (s *Score) UnmarshalJSON(data []byte) error {
+	type plain Score
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	s.Cleared = nil
+	for _, stat := range scoreStats {
+		if v, ok := fields[stat]; ok && string(v) == "null" {
+			if s.Cleared == nil {
+				s.Cleared = make(map[string]bool)
+			}
+			s.Cleared[stat] = true
+		}
+	}
+	return nil
+}
+
+// Scorecard is the sign-off state of one player's card. The marker is
+// another player of the same flight.
+type Scorecard struct {
+	PlayerID    int    `json:"player_id"`
+	Status      string `json:"status"` // in_progress, submitted, accepted or verified
+	MarkerID    int    `json:"marker_id,omitempty"`
+	SubmittedAt string `json:"submitted_at,omitempty"`
+	AcceptedAt  string `json:"accepted_at,omitempty"`
+	VerifiedAt  string `json:"verified_at,omitempty"`
+}
+
+// RoundStatus marks a player who did not complete the round normally: DNF
+// (did not finish), DQ (disqualified), NR (no return) or WD (withdrawn).
+type RoundStatus struct {
+	PlayerID int    `json:"player_id"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	SetAt    string `json:"set_at"`
+	Source   string `json:"source"` // token or admin
+}
+
+// ScoreChange is one entry of the score history. OldStrokes is nil for the
+// first write of a hole.
+type ScoreChange struct {
+	ID         int    `json:"id"`
+	PlayerID   int    `json:"player_id"`
+	HoleNumber int    `json:"hole_number"`
+	OldStrokes *int   `json:"old_strokes"`
+	NewStrokes int    `json:"new_strokes"`
+	ChangedAt  string `json:"changed_at"`
+	Source     string `json:"source"` // token, admin, import or correction
+	FlightID   int    `json:"flight_id,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`  // admin only
+	UserAgent  string `json:"user_agent,omitempty"` // admin only
+	Reason     string `json:"reason,omitempty"`     // given for corrections
+}
+
+// ScoreConflict is a score write that was refused because someone else had
+// changed the score since the client last saw it.
+type ScoreConflict struct {
+	ID            int    `json:"id"`
+	PlayerID      int    `json:"player_id"`
+	HoleNumber    int    `json:"hole_number"`
+	ServerStrokes int    `json:"server_strokes"`
+	ServerVersion int    `json:"server_version"`
+	ClientStrokes int    `json:"client_strokes"`
+	CreatedAt     string `json:"created_at"`
+	Source        string `json:"source"`
+	FlightID      int    `json:"flight_id,omitempty"`
+	ResolvedAt    string `json:"resolved_at,omitempty"`
+}
+
+type HandicapSnapshot struct {
+	PlayerID        int     `json:"player_id"`
+	HandicapIndex   float64 `json:"handicap_index"`
+	PlayingHandicap int     `json:"playing_handicap"`
+	TakenAt         string  `json:"taken_at"`
+}
+
+// Draw is the audit record of one random assignment. Inputs and Pairings are
+// stored as JSON so a draw can be shown or replayed exactly as it was made.
+type Draw struct {
+	ID        int             `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Seed      string          `json:"seed"`
+	Inputs    json.RawMessage `json:"inputs"`
+	Pairings  json.RawMessage `json:"pairings"`
+}
+
+type PairingConstraint struct {
+	ID            int    `json:"id"`
+	Kind          string `json:"kind"` // together, apart or fixed
+	PlayerID      int    `json:"player_id"`
+	OtherPlayerID int    `json:"other_player_id,omitempty"`
+	FlightID      int    `json:"flight_id,omitempty"`
+	Note          string `json:"note"`
 }
@@ -1,5 +1,8 @@
 #!/bin/bash
 
+# Admin credential for flight setup
+export ADMIN_KEY=${ADMIN_KEY:-test-admin-key}
+
 # Start server in background
 ./server &
 SERVER_PID=$!
@@ -9,19 +12,26 @@ echo "--- Testing Import ---"
 curl -F "file=@players.csv" http://localhost:8080/api/players/import
 echo ""
 
+echo "--- Testing Course Import ---"
+# The shipped course has no stroke index column; the indexes have to survive
+curl -s -F "file=@course_config_slapy.csv" http://localhost:8080/api/course/import
+curl -s http://localhost:8080/api/course | jq '{stroke_indexes_kept: all(.[]; .stroke_index > 0)}'
+echo ""
+
 echo "--- Testing List Players ---"
 curl http://localhost:8080/api/players
 echo ""
 
 echo "--- Testing Create Flight ---"
-FLIGHT_RESP=$(curl -s -X POST -d '{"name":"Flight A"}' http://localhost:8080/api/flights)
+FLIGHT_RESP=$(curl -s -X POST -H "X-Admin-Key: $ADMIN_KEY" -d '{"name":"Flight A"}' http://localhost:8080/api/flights)
 echo $FLIGHT_RESP
 FLIGHT_ID=$(echo $FLIGHT_RESP | jq -r '.id')
+FLIGHT_TOKEN=$(echo $FLIGHT_RESP | jq -r '.token')
 echo "Flight ID: $FLIGHT_ID"
 
 echo "--- Testing Assign Player ---"
 # Assign player 1 to flight
-curl -X POST -d "{\"flight_id\":$FLIGHT_ID, \"player_id\":1}" http://localhost:8080/api/flights/assign
+curl -X POST -H "X-Admin-Key: $ADMIN_KEY" -d "{\"flight_id\":$FLIGHT_ID, \"player_id\":1}" http://localhost:8080/api/flights/assign
 echo ""
 
 echo "--- Testing List Flights ---"
@@ -30,20 +40,34 @@ echo ""
 
 echo "--- Testing Submit Score ---"
 # Player 1, Hole 1, Score 4
-curl -X POST -d '{"player_id":1, "hole_number":1, "strokes":4}' http://localhost:8080/api/scores
+curl -X POST -H "X-Flight-Token: $FLIGHT_TOKEN" -d '{"player_id":1, "hole_number":1, "strokes":4}' http://localhost:8080/api/scores
 echo ""
-# Player 1, Hole 2, Score 12 (should be capped at 11)
-curl -X POST -d '{"player_id":1, "hole_number":2, "strokes":12}' http://localhost:8080/api/scores
+# Player 1, Hole 2, Score 12 (should be rejected, over max_strokes)
+curl -X POST -H "X-Flight-Token: $FLIGHT_TOKEN" -d '{"player_id":1, "hole_number":2, "strokes":12}' http://localhost:8080/api/scores
 echo ""
 
 echo "--- Testing Get Scores ---"
 curl "http://localhost:8080/api/scores?player_id=1"
 echo ""
 
+echo "--- Testing Clearing a Hole Stat ---"
+# Putts sent as null are cleared; left out they would be kept
+curl -s -X POST -H "X-Flight-Token: $FLIGHT_TOKEN" -d '{"player_id":1, "hole_number":1, "strokes":4, "putts":2}' http://localhost:8080/api/scores
+curl -s -X POST -H "X-Flight-Token: $FLIGHT_TOKEN" -d '{"player_id":1, "hole_number":1, "strokes":4}' http://localhost:8080/api/scores
+curl -s "http://localhost:8080/api/stats/players?player_id=1" | jq '{putts_kept: (.putts_holes == 1)}'
+curl -s -X POST -H "X-Flight-Token: $FLIGHT_TOKEN" -d '{"player_id":1, "hole_number":1, "strokes":4, "putts":null}' http://localhost:8080/api/scores
+curl -s "http://localhost:8080/api/stats/players?player_id=1" | jq '{putts_cleared: (.putts_holes == 0)}'
+echo ""
+
 echo "--- Testing Results ---"
 curl http://localhost:8080/api/results
 echo ""
 
+echo "--- Testing Net Score ---"
+# Without a course rating net is gross less the handicap index
+curl -s http://localhost:8080/api/results | jq '.[] | select(.id == 1) | {gross, handicap, net, ok: (.net == .gross - .handicap)}'
+echo ""
+
 # Kill server
 kill $SERVER_PID
 rm tournament.db
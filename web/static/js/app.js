@@ -10,9 +10,27 @@ createApp({
         const course = ref([]);
         const newFlightName = ref('');
         const newFlightStartingHole = ref(1);
+        const drawStrategy = ref('random');
+        const drawAutoCreate = ref(false);
         const flightToken = ref('');
+        const adminKey = ref(localStorage.getItem('adminKey') || '');
         const currentFlight = ref(null);
         const scores = ref({}); // Map of playerID -> hole -> strokes
+        const versions = ref({}); // Server version of each score, sent back on writes
+        const cards = ref({}); // Map of playerID -> scorecard sign-off state
+        const roundStatuses = ref({}); // Map of playerID -> DNF/DQ/NR/WD with reason
+        const roundStatusLabels = {
+            DNF: 'Nedokončil',
+            DQ: 'Diskvalifikován',
+            NR: 'Neodevzdal kartu',
+            WD: 'Odstoupil'
+        };
+        const cardStatusLabels = {
+            in_progress: 'Rozehráno',
+            submitted: 'Odevzdáno zapisovatelem',
+            accepted: 'Potvrzeno hráčem',
+            verified: 'Ověřeno komisí'
+        };
         const showWarning = ref(false);
         const warningMessage = ref('');
         const scoringEnabled = ref(true);
@@ -46,7 +64,10 @@ createApp({
 
         // Fetch Flights
         const fetchFlights = async () => {
-            const res = await fetch('/api/flights');
+            // Tokens are only included for the admin
+            const res = await fetch('/api/flights', {
+                headers: { 'X-Admin-Key': adminKey.value }
+            });
             flights.value = await res.json();
             setupDragAndDrop();
         };
@@ -57,6 +78,11 @@ createApp({
             results.value = await res.json();
         };
 
+        const saveAdminKey = () => {
+            localStorage.setItem('adminKey', adminKey.value);
+            fetchFlights();
+        };
+
         const fetchSettings = async () => {
             const res = await fetch('/api/settings');
             const data = await res.json();
@@ -68,7 +94,7 @@ createApp({
         const updateSettings = async () => {
             await fetch('/api/settings', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({
                     scoring_enabled: scoringEnabled.value ? '1' : '0'
                 })
@@ -153,7 +179,7 @@ createApp({
             if (!newFlightName.value) return;
             await fetch('/api/flights', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({
                     name: newFlightName.value,
                     starting_hole: newFlightStartingHole.value
@@ -168,7 +194,7 @@ createApp({
         const updateFlight = async (flight) => {
             await fetch('/api/flights/update', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({
                     id: flight.id,
                     name: flight.name,
@@ -179,8 +205,37 @@ createApp({
         };
 
         const randomAssign = async () => {
-            if (!confirm('Tato akce náhodně přiřadí všechny zbylé hráče do neobsazených míst ve flightech. Pokračovat?')) return;
-            await fetch('/api/flights/random-assign', { method: 'POST' });
+            // Preview first, then commit the exact same draw using the returned seed
+            const previewRes = await fetch('/api/flights/random-assign', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
+                body: JSON.stringify({ strategy: drawStrategy.value, auto_create: drawAutoCreate.value, preview: true })
+            });
+            const preview = await previewRes.json();
+            if (!preview.flights || preview.flights.length === 0) {
+                alert('Není koho přiřadit.');
+                return;
+            }
+
+            const names = (id) => {
+                // Flights the draw would create have negative IDs in the preview
+                if (id < 0) return preview.new_flights[-id - 1].name + ' (nový)';
+                const f = flights.value.find(f => f.id === id);
+                return f ? f.name : id;
+            };
+            const lines = preview.flights.map(f =>
+                `${names(f.flight_id)}: ${f.players.map(p => p.surname).join(', ')} (Ø HCP ${f.average_handicap})`
+            );
+            if (preview.unassigned.length > 0) {
+                lines.push(`Nepřiřazeno: ${preview.unassigned.length}`);
+            }
+            if (!confirm('Návrh rozlosování:\n\n' + lines.join('\n') + '\n\nPotvrdit?')) return;
+
+            await fetch('/api/flights/random-assign', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
+                body: JSON.stringify({ strategy: preview.strategy, auto_create: drawAutoCreate.value, seed: preview.seed })
+            });
             fetchFlights();
         };
         // Delete Flight
@@ -188,7 +243,7 @@ createApp({
             // if (!confirm('Are you sure you want to delete this flight? Players will be unassigned.')) return;
             await fetch('/api/flights', {
                 method: 'DELETE',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({ id })
             });
             fetchFlights();
@@ -250,7 +305,7 @@ createApp({
         const assignPlayer = async (flightId, playerId) => {
             await fetch('/api/flights/assign', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({ flight_id: flightId, player_id: playerId })
             });
             // Refresh to ensure state is consistent
@@ -261,7 +316,7 @@ createApp({
         const unassignPlayer = async (playerId) => {
             await fetch('/api/flights/unassign', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
                 body: JSON.stringify({ player_id: playerId })
             });
             fetchFlights();
@@ -270,48 +325,196 @@ createApp({
 
         // Scoring Logic
         const loadFlight = async () => {
-            // In a real app, we'd search by token. For now, let's just find the flight in the list
-            // But the API doesn't support search by token yet. 
-            // Let's just fetch all flights and filter client side for this prototype
-            await fetchFlights();
-            currentFlight.value = flights.value.find(f => f.token === flightToken.value);
-            if (!currentFlight.value) {
-                alert('Flight not found');
+            // The server looks the flight up by token; rotated or expired
+            // tokens are rejected
+            const res = await fetch('/api/flight', {
+                headers: { 'X-Flight-Token': flightToken.value }
+            });
+            if (!res.ok) {
+                alert('Odkaz na flight je neplatný nebo vypršel.');
                 return;
             }
-
-            // Fetch scores for all players in flight
-            for (const player of currentFlight.value.players) {
-                const res = await fetch(`/api/scores?player_id=${player.id}`);
-                const playerScores = await res.json();
+            const data = await res.json();
+            currentFlight.value = data.flight;
+            course.value = data.course;
+            applyFlightState(data);
+            syncScores();
+        };
+
+        // Server state wins, except for scores still waiting in the queue
+        const applyFlightState = (state) => {
+            cards.value = state.cards;
+            roundStatuses.value = state.round_statuses || {};
+            scoringEnabled.value = state.scoring_enabled;
+            scores.value = {};
+            versions.value = {};
+            for (const [playerId, playerScores] of Object.entries(state.scores)) {
                 for (const [hole, strokes] of Object.entries(playerScores)) {
-                    scores.value[`${player.id}-${hole}`] = strokes;
+                    scores.value[`${playerId}-${hole}`] = strokes;
+                    versions.value[`${playerId}-${hole}`] = state.versions[playerId][hole];
                 }
             }
+            for (const e of loadQueue()) {
+                scores.value[`${e.player_id}-${e.hole_number}`] = e.strokes;
+            }
         };
 
-        const getScore = (playerId, hole) => {
-            return scores.value[`${playerId}-${hole}`] || '';
+        // Scorecard sign-off: the marker submits, the player accepts
+        const setCardStatus = async (playerId, status) => {
+            const res = await fetch('/api/scorecards/status', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Flight-Token': flightToken.value },
+                body: JSON.stringify({ player_id: playerId, status })
+            });
+            if (!res.ok) {
+                alert(await res.text());
+                return;
+            }
+            cards.value[playerId] = await res.json();
         };
 
-        const submitScore = async (playerId, hole, strokes) => {
-            if (!scoringEnabled.value) return;
+        const setMarker = async (playerId, markerId) => {
+            const res = await fetch('/api/scorecards/marker', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Flight-Token': flightToken.value },
+                body: JSON.stringify({ player_id: playerId, marker_id: markerId })
+            });
+            if (!res.ok) {
+                alert(await res.text());
+                return;
+            }
+            cards.value[playerId].marker_id = markerId;
+        };
 
-            scores.value[`${playerId}-${hole}`] = strokes;
-            const res = await fetch('/api/scores', {
+        // Committee actions from the results tab
+        const adminSetCardStatus = async (playerId, status) => {
+            const res = await fetch('/api/scorecards/status', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({
-                    player_id: playerId,
-                    hole_number: hole,
-                    strokes: parseInt(strokes)
-                })
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
+                body: JSON.stringify({ player_id: playerId, status })
             });
+            if (!res.ok) {
+                alert(await res.text());
+            }
+            fetchResults();
+        };
 
-            if (res.status === 403) {
-                alert('Zapisování výsledků je ukončeno.');
-                location.reload();
+        // Round status (DNF, DQ, NR, WD): the scorer marks a player of the
+        // flight, the committee can also change or clear it
+        const askRoundStatus = (clearable) => {
+            const codes = Object.keys(roundStatusLabels).join(', ');
+            const status = prompt(clearable
+                ? `Stav kola (${codes}), prázdné pro zrušení:`
+                : `Stav kola (${codes}):`);
+            if (status === null || (!clearable && status.trim() === '')) return null;
+            let reason = '';
+            if (status.trim() !== '') {
+                reason = prompt('Důvod:');
+                if (reason === null) return null;
+            }
+            return { status: status.trim().toUpperCase(), reason };
+        };
+
+        const postRoundStatus = async (playerId, choice, headers) => {
+            const res = await fetch('/api/round-status', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...headers },
+                body: JSON.stringify({ player_id: playerId, ...choice })
+            });
+            if (res.status === 400) {
+                const data = await res.json();
+                alert(data.fields.map(f => `${f.field}: ${f.message}`).join('\n'));
+                return false;
+            }
+            if (!res.ok) {
+                alert(await res.text());
+                return false;
+            }
+            return true;
+        };
+
+        const setRoundStatus = async (playerId) => {
+            const choice = askRoundStatus(false);
+            if (!choice) return;
+            if (await postRoundStatus(playerId, choice, { 'X-Flight-Token': flightToken.value })) {
+                await loadFlight();
+            }
+        };
+
+        const adminSetRoundStatus = async (playerId) => {
+            const choice = askRoundStatus(true);
+            if (!choice) return;
+            await postRoundStatus(playerId, choice, { 'X-Admin-Key': adminKey.value });
+            fetchResults();
+        };
+
+        // Unresolved score conflicts for the committee
+        const conflicts = ref([]);
+        const fetchConflicts = async () => {
+            const res = await fetch('/api/scores/conflicts', {
+                headers: { 'X-Admin-Key': adminKey.value }
+            });
+            conflicts.value = res.ok ? await res.json() : [];
+        };
+
+        const resolveConflict = async (conflict) => {
+            await fetch('/api/scores/conflicts', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
+                body: JSON.stringify({ id: conflict.id })
+            });
+            fetchConflicts();
+        };
+
+        const playerName = (id) => {
+            const p = players.value.find(p => p.id === id);
+            return p ? `${p.name} ${p.surname}` : `#${id}`;
+        };
+
+        const showScoreHistory = async (player) => {
+            const res = await fetch(`/api/scores/history?player_id=${player.id}`, {
+                headers: { 'X-Admin-Key': adminKey.value }
+            });
+            if (!res.ok) {
+                alert(await res.text());
+                return;
+            }
+            const history = await res.json();
+            const lines = [];
+            for (const [hole, changes] of Object.entries(history)) {
+                for (const c of changes) {
+                    const from = c.old_strokes === null ? '-' : c.old_strokes;
+                    const reason = c.reason ? `, ${c.reason}` : '';
+                    lines.push(`#${hole}: ${from} → ${c.new_strokes}  (${c.source}, ${c.changed_at}${reason})`);
+                }
+            }
+            alert(`${player.name} ${player.surname}\n\n` + (lines.join('\n') || 'Žádné změny.'));
+        };
+
+        // Committee correction, works even with scoring closed
+        const correctScore = async (player) => {
+            const hole = parseInt(prompt(`${player.name} ${player.surname}: číslo jamky`));
+            if (!hole) return;
+            const strokes = parseInt(prompt(`Jamka ${hole}: správný počet ran`, player.scores[hole] || ''));
+            if (!strokes) return;
+            const reason = prompt('Důvod opravy:');
+            if (reason === null) return;
+            const res = await fetch('/api/scores/correct', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Admin-Key': adminKey.value },
+                body: JSON.stringify({ player_id: player.id, hole_number: hole, strokes, reason })
+            });
+            if (res.status === 400) {
+                const data = await res.json();
+                alert(data.fields.map(f => `${f.field}: ${f.message}`).join('\n'));
+            } else if (!res.ok) {
+                alert(await res.text());
             }
+            fetchResults();
+        };
+
+        const getScore = (playerId, hole) => {
+            return scores.value[`${playerId}-${hole}`] || '';
         };
 
         // ... (inside setup)
@@ -326,33 +529,41 @@ createApp({
 
         // ... (inside setup)
 
-        const generateQRs = () => {
-            nextTick(() => {
-                flights.value.forEach(flight => {
-                    const container = document.getElementById('qr-' + flight.id);
-                    if (container) {
-                        container.innerHTML = '';
-                        new QRCode(container, {
-                            text: `https://vanoce.jdark.org/?t=${flight.token}`,
-                            width: 150,
-                            height: 150,
-                            colorDark: "#000000",
-                            colorLight: "#ffffff",
-                            correctLevel: QRCode.CorrectLevel.L
-                        });
-                    }
+        // QR codes are generated by the server; they contain the token, so
+        // they're fetched with the admin key and shown from blob URLs
+        const qrImages = ref({});
+        const generateQRs = async () => {
+            for (const flight of flights.value) {
+                const res = await fetch(`/api/flights/qr?id=${flight.id}&size=150`, {
+                    headers: { 'X-Admin-Key': adminKey.value }
                 });
+                if (!res.ok) continue;
+                qrImages.value[flight.id] = URL.createObjectURL(await res.blob());
+            }
+        };
+
+        // Printable documents need the admin key, so they're fetched first
+        // and opened from a blob URL
+        const openAdminDocument = async (url) => {
+            const res = await fetch(url, {
+                headers: { 'X-Admin-Key': adminKey.value }
             });
+            if (!res.ok) {
+                alert('Dokument se nepodařilo vytvořit.');
+                return;
+            }
+            window.open(URL.createObjectURL(await res.blob()), '_blank');
         };
 
+        const printQRSheet = () => openAdminDocument('/api/flights/qr-sheet');
+        const printScorecards = (flight) => openAdminDocument(flight ? `/api/flights/scorecards?id=${flight.id}` : '/api/flights/scorecards');
+
         const downloadQR = (flight) => {
-            const container = document.getElementById('qr-' + flight.id);
-            if (!container) return;
-            const img = container.querySelector('img');
-            if (!img) return;
+            const src = qrImages.value[flight.id];
+            if (!src) return;
 
             const link = document.createElement('a');
-            link.href = img.src;
+            link.href = src;
             link.download = `${flight.name}_${flight.token}.png`;
             document.body.appendChild(link);
             link.click();
@@ -373,6 +584,8 @@ createApp({
                 fetchFlights();
             } else if (newVal === 'flights-qr') {
                 generateQRs();
+            } else if (newVal === 'results') {
+                fetchConflicts();
             }
         });
 
@@ -395,6 +608,8 @@ createApp({
                 view.value = 'scoring';
                 flightToken.value = tokenParam;
                 loadFlight();
+                window.addEventListener('online', syncScores);
+                setInterval(syncScores, 30000);
             } else {
                 view.value = 'landing';
             }
@@ -480,12 +695,118 @@ createApp({
         };
 
         const confirmScore = async () => {
-            // Submit scores for all players
-            const promises = Object.entries(pickerValues.value).map(([playerId, val]) => {
-                return submitScore(parseInt(playerId), pickerHole.value, val);
-            });
-            await Promise.all(promises);
+            if (!scoringEnabled.value) return;
+
+            // Submit the whole flight's scores for the hole in one request
+            const entries = Object.entries(pickerValues.value).map(([playerId, val]) => ({
+                player_id: parseInt(playerId),
+                hole_number: pickerHole.value,
+                strokes: parseInt(val),
+                base_version: versions.value[`${playerId}-${pickerHole.value}`] || 0
+            }));
             showPicker.value = false;
+            await sendScores(entries);
+        };
+
+        // Offline queue: scores are queued in localStorage first and sent
+        // through the sync endpoint, so nothing is lost without reception
+        const pendingCount = ref(0);
+        const queueKey = () => `scoreQueue-${flightToken.value}`;
+        const loadQueue = () => JSON.parse(localStorage.getItem(queueKey()) || '[]');
+        const saveQueue = (queue) => {
+            localStorage.setItem(queueKey(), JSON.stringify(queue));
+            pendingCount.value = queue.length;
+        };
+        const newIdempotencyKey = () => (window.crypto && crypto.randomUUID)
+            ? crypto.randomUUID()
+            : `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+
+        const sendScores = async (entries) => {
+            let queue = loadQueue();
+            for (const e of entries) {
+                scores.value[`${e.player_id}-${e.hole_number}`] = e.strokes;
+                // A newer edit of a hole still waiting replaces it, keeping
+                // the version the first edit was based on
+                const waiting = queue.find(q => q.player_id === e.player_id && q.hole_number === e.hole_number);
+                const entry = { ...e, idempotency_key: newIdempotencyKey(), captured_at: new Date().toISOString() };
+                if (waiting) {
+                    entry.base_version = waiting.base_version;
+                    queue = queue.filter(q => q !== waiting);
+                }
+                queue.push(entry);
+            }
+            saveQueue(queue);
+            await syncScores();
+        };
+
+        let syncing = false;
+        const syncScores = async () => {
+            const queue = loadQueue();
+            pendingCount.value = queue.length;
+            if (queue.length === 0 || syncing) return;
+
+            syncing = true;
+            let res;
+            try {
+                res = await fetch('/api/scores/sync', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', 'X-Flight-Token': flightToken.value },
+                    body: JSON.stringify({ entries: queue })
+                });
+            } catch (e) {
+                return; // Offline - the queue is sent again later
+            } finally {
+                syncing = false;
+            }
+            if (res.status === 403) {
+                alert('Zapisování výsledků je ukončeno.');
+                location.reload();
+                return;
+            }
+            if (!res.ok) return;
+
+            // Everything sent has been answered; keep what was queued meanwhile
+            const data = await res.json();
+            const sent = new Set(queue.map(e => e.idempotency_key));
+            // Edits queued meanwhile on the same base build on what was just saved
+            const saved = {};
+            data.results.forEach((r, i) => {
+                if (r.status === 'saved' && r.version) {
+                    saved[`${r.player_id}-${r.hole_number}-${queue[i].base_version}`] = r.version;
+                }
+            });
+            saveQueue(loadQueue().filter(e => !sent.has(e.idempotency_key)).map(e => {
+                const v = saved[`${e.player_id}-${e.hole_number}-${e.base_version}`];
+                return v ? { ...e, base_version: v } : e;
+            }));
+            applyFlightState(data.state);
+
+            const overrides = [];
+            const rejected = [];
+            for (const r of data.results) {
+                if (r.status === 'conflict' && !r.duplicate) {
+                    // Someone else in the flight entered this hole in the meantime
+                    const player = currentFlight.value.players.find(p => p.id === r.player_id);
+                    const name = player ? player.name : '';
+                    if (confirm(`${name}, jamka ${r.hole_number}: někdo jiný zapsal ${r.server_strokes}, vy jste zadali ${r.strokes}. Přepsat?`)) {
+                        overrides.push({
+                            player_id: r.player_id,
+                            hole_number: r.hole_number,
+                            strokes: r.strokes,
+                            base_version: r.server_version
+                        });
+                    }
+                } else if (r.status === 'rejected' && !r.duplicate) {
+                    rejected.push(`jamka ${r.hole_number}: ${r.error}`);
+                }
+            }
+            if (rejected.length > 0) {
+                // e.g. a card that was already submitted or an invalid score
+                alert('Některé výsledky nebyly uloženy:\n' + rejected.join('\n'));
+            }
+            if (overrides.length > 0) {
+                await sendScores(overrides);
+            }
         };
 
         const getPar = (hole) => {
@@ -542,6 +863,8 @@ createApp({
             newFlightName,
             newFlightStartingHole,
             flightToken,
+            adminKey,
+            saveAdminKey,
             currentFlight,
             unassignedPlayers,
             playerForm,
@@ -557,7 +880,21 @@ createApp({
             deleteFlight,
             loadFlight,
             getScore,
-            submitScore,
+            pendingCount,
+            cards,
+            cardStatusLabels,
+            setCardStatus,
+            setMarker,
+            adminSetCardStatus,
+            roundStatuses,
+            roundStatusLabels,
+            setRoundStatus,
+            adminSetRoundStatus,
+            showScoreHistory,
+            correctScore,
+            conflicts,
+            resolveConflict,
+            playerName,
             getInitials,
             showPicker,
             showWarning,
@@ -576,9 +913,14 @@ createApp({
             getPlayerTotal,
             getScoreStyle,
             isHoleScored,
+            qrImages,
             downloadQR,
             downloadAllQRs,
+            printQRSheet,
+            printScorecards,
             randomAssign,
+            drawStrategy,
+            drawAutoCreate,
             isFetchingHCP,
             fetchHCPs,
             capitalize,